@@ -5,6 +5,8 @@ go 1.22.0
 require (
 	github.com/gin-gonic/gin v1.10.0
 	github.com/missuo/xdk-go v0.0.0-20260209044214-8f7f9c60775f
+	golang.org/x/crypto v0.23.0
+	golang.org/x/text v0.15.0
 )
 
 require (
@@ -28,10 +30,8 @@ require (
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/ugorji/go/codec v1.2.12 // indirect
 	golang.org/x/arch v0.8.0 // indirect
-	golang.org/x/crypto v0.23.0 // indirect
 	golang.org/x/net v0.25.0 // indirect
 	golang.org/x/sys v0.20.0 // indirect
-	golang.org/x/text v0.15.0 // indirect
 	google.golang.org/protobuf v1.34.1 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
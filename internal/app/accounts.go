@@ -0,0 +1,117 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultAccountName refers to the top-level "x" profile in the config.
+const defaultAccountName = "default"
+
+var errUnknownAccount = errors.New("unknown account")
+
+// resolveAccountName maps a requested account to a profile name. An empty
+// request selects default_account, falling back to the top-level profile.
+func (c *Config) resolveAccountName(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = strings.TrimSpace(c.DefaultAccount)
+	}
+	if name == "" {
+		return defaultAccountName
+	}
+	return name
+}
+
+func (c *Config) accountAuth(name string) (*XAuthConfig, string, error) {
+	resolved := c.resolveAccountName(name)
+	if resolved == defaultAccountName {
+		return &c.X, resolved, nil
+	}
+	auth, ok := c.Accounts[resolved]
+	if !ok || auth == nil {
+		return nil, resolved, fmt.Errorf("%w: %s", errUnknownAccount, resolved)
+	}
+	return auth, resolved, nil
+}
+
+func (c *Config) accountNames() []string {
+	names := make([]string, 0, len(c.Accounts)+1)
+	for name, auth := range c.Accounts {
+		if name == defaultAccountName || auth == nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{defaultAccountName}, names...)
+}
+
+// ensureAnyAccountConfigured reports whether at least one profile has usable
+// credentials.
+func ensureAnyAccountConfigured(cfg *Config) error {
+	err := ensureFirstBootAuthConfigured(cfg.X)
+	if err == nil {
+		return nil
+	}
+	for _, name := range cfg.accountNames() {
+		auth, _, authErr := cfg.accountAuth(name)
+		if authErr == nil && ensureFirstBootAuthConfigured(*auth) == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func newAccountPoster(cfg *Config, name string) (*Poster, error) {
+	auth, resolved, err := cfg.accountAuth(name)
+	if err != nil {
+		return nil, err
+	}
+	poster, err := newPoster(resolved, *auth)
+	if err != nil {
+		if resolved != defaultAccountName {
+			return nil, fmt.Errorf("account %s: %w", resolved, err)
+		}
+		return nil, err
+	}
+	return poster, nil
+}
+
+// requestAccount picks the account named in the request body, falling back
+// to the X-Xpost-Account header.
+func requestAccount(c *gin.Context, fromBody string) string {
+	if v := strings.TrimSpace(fromBody); v != "" {
+		return v
+	}
+	return strings.TrimSpace(c.GetHeader("X-Xpost-Account"))
+}
+
+// posterForRequest resolves the poster for an account and writes the error
+// response itself when that fails.
+func (a *App) posterForRequest(c *gin.Context, account string) (*Poster, bool) {
+	poster, err := a.getPoster(account)
+	if err != nil {
+		c.JSON(errorResponse(err, http.StatusServiceUnavailable, codeAccountNotReady))
+		return nil, false
+	}
+	if !checkAccountAllowed(c, poster.account) {
+		return nil, false
+	}
+	return poster, true
+}
+
+func (a *App) accountUserID(account string) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	auth, _, err := a.cfg.accountAuth(account)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(auth.UserID)
+}
@@ -1,10 +1,9 @@
 package app
 
 import (
-	"bytes"
 	"context"
 	"crypto/rand"
-	"crypto/subtle"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -12,14 +11,15 @@ import (
 	"io"
 	"log"
 	"math"
-	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	xdk "github.com/missuo/xdk-go"
@@ -29,7 +29,6 @@ const (
 	defaultServerAddr  = ":8080"
 	defaultRedirectURI = "http://localhost:9100"
 	maxMediaCount      = 4
-	maxMediaBytes      = 8 * 1024 * 1024
 )
 
 func defaultConfigPath() string {
@@ -44,14 +43,43 @@ type Config struct {
 	Server   ServerConfig   `json:"server"`
 	Security SecurityConfig `json:"security"`
 	X        XAuthConfig    `json:"x"`
+	// Accounts holds additional named X profiles. The top-level X profile is
+	// addressed as "default".
+	Accounts       map[string]*XAuthConfig `json:"accounts,omitempty"`
+	DefaultAccount string                  `json:"default_account,omitempty"`
+	Secrets        *SecretsConfig          `json:"secrets,omitempty"`
+	Images         *ImageConfig            `json:"images,omitempty"`
+
+	masterKey []byte
 }
 
 type ServerConfig struct {
 	Addr string `json:"addr"`
+	// MediaURLAllowNetworks are CIDRs that media_urls may reach even though
+	// they are private, loopback or link-local.
+	MediaURLAllowNetworks []string `json:"media_url_allow_networks,omitempty"`
+	// IdempotencyTTL is how long Idempotency-Key responses are kept, such as
+	// "24h" or "7d".
+	IdempotencyTTL string `json:"idempotency_ttl,omitempty"`
+	// Outbox makes POST /v1/tweets store posts on disk and send them in the
+	// background, retrying while X or the credentials are unavailable.
+	Outbox bool `json:"outbox,omitempty"`
+	// Metrics serves Prometheus metrics at /metrics to API tokens with the
+	// metrics:read scope.
+	Metrics bool `json:"metrics,omitempty"`
+	// MetricsAddr serves /metrics on its own address instead, without auth,
+	// such as "127.0.0.1:9090" for an admin network.
+	MetricsAddr string `json:"metrics_addr,omitempty"`
 }
 
 type SecurityConfig struct {
-	APIToken string `json:"api_token"`
+	// APIToken is the plaintext token written by older versions. It is moved
+	// into APITokens as a hash when the config is loaded.
+	APIToken  string     `json:"api_token,omitempty"`
+	APITokens []APIToken `json:"api_tokens,omitempty"`
+
+	envToken       string
+	generatedToken string
 }
 
 type XAuthConfig struct {
@@ -71,34 +99,147 @@ type XAuthConfig struct {
 }
 
 type App struct {
-	mu         sync.RWMutex
-	cfg        *Config
-	configPath string
-	persistCfg bool
-	poster     *Poster
-	posterErr  error
+	mu          sync.RWMutex
+	cfg         *Config
+	configPath  string
+	persistCfg  bool
+	posters     map[string]*Poster
+	posterErrs  map[string]error
+	schedule    *scheduleStore
+	fetcher     *mediaFetcher
+	library     *mediaLibrary
+	idempotency *idempotencyStore
+	jobs        *jobQueue
+	outbox      *outboxStore
+	cfgModTime  time.Time
+
+	tokenCacheMu sync.Mutex
+	tokenCache   map[[sha256.Size]byte]string
 }
 
 type Poster struct {
 	client   *xdk.Client
 	authMode string
+	account  string
 }
 
 type MediaRef struct {
 	ID       string `json:"id,omitempty"`
 	MediaKey string `json:"media_key,omitempty"`
+	AltText  string `json:"alt_text,omitempty"`
+	// Transforms lists what preprocessing changed, such as "resized".
+	Transforms []string   `json:"transforms,omitempty"`
+	SHA256     string     `json:"sha256,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	// Reused is set when the media library already held these bytes.
+	Reused bool `json:"reused,omitempty"`
 }
 
 type createTweetJSONRequest struct {
 	Text              string   `json:"text"`
 	MediaBase64       []string `json:"media_base64"`
 	MediaContentTypes []string `json:"media_content_types"`
+	MediaURLs         []string `json:"media_urls"`
+	MediaIDs          []string `json:"media_ids"`
+	AltText           []string `json:"alt_text"`
 	ReplyToTweetID    string   `json:"reply_to_tweet_id"`
+	PublishAt         string   `json:"publish_at"`
+	Account           string   `json:"account"`
+	// AutoThread splits text that is too long into a reply chain instead of
+	// rejecting it; AutoThreadNumbering adds " i/n" to each post.
+	AutoThread          bool `json:"auto_thread"`
+	AutoThreadNumbering bool `json:"auto_thread_numbering"`
+	Async               bool `json:"async"`
+	tweetOptions
+}
+
+// tweetRequest is a parsed create-tweet request. Everything except the media
+// bytes is JSON-serializable so scheduled posts can be persisted as-is.
+type tweetRequest struct {
+	Text           string             `json:"text,omitempty"`
+	Media          []mediaUploadInput `json:"-"`
+	MediaURLs      []mediaURL         `json:"-"`
+	MediaIDs       []string           `json:"media_ids,omitempty"`
+	ReplyToTweetID string             `json:"reply_to_tweet_id,omitempty"`
+	Account        string             `json:"account,omitempty"`
+	PublishAt      time.Time          `json:"-"`
+	tweetOptions
+	// threadParts holds the split text when auto_thread had to break it up.
+	threadParts []string
+	// async posts in the background and answers with a job to poll.
+	async bool
+}
+
+// tweetOptions are the optional post settings passed through to X as-is.
+type tweetOptions struct {
+	QuoteTweetID          string     `json:"quote_tweet_id,omitempty"`
+	ReplySettings         string     `json:"reply_settings,omitempty"`
+	ExcludeReplyUserIDs   []string   `json:"exclude_reply_user_ids,omitempty"`
+	ForSuperFollowersOnly bool       `json:"for_super_followers_only,omitempty"`
+	Poll                  *tweetPoll `json:"poll,omitempty"`
 }
 
-type mediaUploadInput struct {
-	Data        []byte
-	ContentType string
+type tweetPoll struct {
+	Options         []string `json:"options"`
+	DurationMinutes int      `json:"duration_minutes"`
+}
+
+// Poll limits enforced by X.
+const (
+	minPollOptions         = 2
+	maxPollOptions         = 4
+	maxPollOptionChars     = 25
+	minPollDurationMinutes = 5
+	maxPollDurationMinutes = 7 * 24 * 60
+)
+
+// normalize trims the options and rejects combinations X would refuse. It
+// runs before any media is uploaded.
+func (o *tweetOptions) normalize(replyToTweetID string, mediaCount int) error {
+	o.QuoteTweetID = strings.TrimSpace(o.QuoteTweetID)
+	o.ReplySettings = strings.TrimSpace(o.ReplySettings)
+	o.ExcludeReplyUserIDs = uniqueNonEmpty(o.ExcludeReplyUserIDs)
+
+	switch o.ReplySettings {
+	case "", "following", "mentionedUsers":
+	default:
+		return fmt.Errorf("invalid reply_settings %q, use following or mentionedUsers", o.ReplySettings)
+	}
+	if len(o.ExcludeReplyUserIDs) > 0 && strings.TrimSpace(replyToTweetID) == "" {
+		return errors.New("exclude_reply_user_ids requires reply_to_tweet_id")
+	}
+	if o.Poll != nil {
+		if mediaCount > 0 {
+			return errors.New("a poll cannot be combined with media")
+		}
+		if o.QuoteTweetID != "" {
+			return errors.New("a poll cannot be combined with quote_tweet_id")
+		}
+		return o.Poll.normalize()
+	}
+	return nil
+}
+
+func (p *tweetPoll) normalize() error {
+	options := make([]string, 0, len(p.Options))
+	for i, option := range p.Options {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			return fmt.Errorf("poll option %d is empty", i+1)
+		}
+		if n := utf8.RuneCountInString(option); n > maxPollOptionChars {
+			return fmt.Errorf("poll option %d is %d characters, max is %d", i+1, n, maxPollOptionChars)
+		}
+		options = append(options, option)
+	}
+	if len(options) < minPollOptions || len(options) > maxPollOptions {
+		return fmt.Errorf("a poll needs %d to %d options, got %d", minPollOptions, maxPollOptions, len(options))
+	}
+	if p.DurationMinutes < minPollDurationMinutes || p.DurationMinutes > maxPollDurationMinutes {
+		return fmt.Errorf("poll duration must be %d to %d minutes", minPollDurationMinutes, maxPollDurationMinutes)
+	}
+	p.Options = options
+	return nil
 }
 
 func RunLocal() error {
@@ -122,23 +263,47 @@ func RunLocal() error {
 		}
 	}
 
+	fetcher, err := newMediaFetcher(cfg.Server.MediaURLAllowNetworks)
+	if err != nil {
+		return err
+	}
+	idempotencyTTL, err := parseIdempotencyTTL(cfg.Server.IdempotencyTTL)
+	if err != nil {
+		return err
+	}
+
 	app := &App{
-		cfg:        cfg,
-		configPath: configPath,
-		persistCfg: true,
+		cfg:         cfg,
+		configPath:  configPath,
+		persistCfg:  true,
+		schedule:    newScheduleStore(filepath.Dir(configPath)),
+		fetcher:     fetcher,
+		library:     newMediaLibrary(filepath.Dir(configPath)),
+		idempotency: newIdempotencyStore(idempotencyTTL, filepath.Dir(configPath)),
+		jobs:        newJobQueue(jobWorkers),
+	}
+	if cfg.Server.Outbox {
+		app.outbox = newOutboxStore(filepath.Dir(configPath))
+	}
+	app.refreshPosters()
+	app.registerQueueMetrics()
+	go app.runScheduler(context.Background())
+	go app.runOutbox(context.Background())
+	if addr := strings.TrimSpace(cfg.Server.MetricsAddr); addr != "" {
+		go runMetricsServer(addr)
 	}
-	app.refreshPoster()
 
 	if firstBoot {
 		log.Printf("first boot: config initialized at %s", configPath)
-		if strings.TrimSpace(os.Getenv("XPOST_API_TOKEN")) == "" {
-			log.Printf("first boot: API token auto-generated, see config file")
-		} else {
+		if strings.TrimSpace(os.Getenv("XPOST_API_TOKEN")) != "" {
 			log.Printf("first boot: API token loaded from XPOST_API_TOKEN")
 		}
 	}
-	if app.posterErr != nil {
-		log.Printf("x auth is not ready yet: %v", app.posterErr)
+	announceGeneratedToken(os.Stderr, cfg.Security.generatedToken, configPath)
+	for _, name := range cfg.accountNames() {
+		if err := app.posterErrs[name]; err != nil {
+			log.Printf("x auth for account %s is not ready yet: %v", name, err)
+		}
 	}
 
 	router := newRouter(app)
@@ -153,21 +318,40 @@ func NewVercelHandler() (http.Handler, error) {
 		},
 	}
 	overrideConfigFromEnv(cfg)
-	if strings.TrimSpace(cfg.Security.APIToken) == "" {
+	if strings.TrimSpace(cfg.Security.envToken) == "" {
 		return nil, errors.New("XPOST_API_TOKEN is required in Vercel environment")
 	}
 	if err := ensureFirstBootAuthConfigured(cfg.X); err != nil {
 		return nil, err
 	}
+	if cfg.Server.Outbox {
+		return nil, errors.New("XPOST_OUTBOX needs a disk that outlives the request and is not supported on Vercel")
+	}
+	if strings.TrimSpace(cfg.Server.MetricsAddr) != "" {
+		return nil, errors.New("XPOST_METRICS_ADDR needs a second listener and is not supported on Vercel, use XPOST_METRICS instead")
+	}
+
+	fetcher, err := newMediaFetcher(cfg.Server.MediaURLAllowNetworks)
+	if err != nil {
+		return nil, err
+	}
+	idempotencyTTL, err := parseIdempotencyTTL(cfg.Server.IdempotencyTTL)
+	if err != nil {
+		return nil, err
+	}
 
 	app := &App{
 		cfg:        cfg,
 		configPath: "",
 		persistCfg: false,
+		fetcher:    fetcher,
+		// Serverless instances come and go, so keys are only remembered by
+		// the instance that saw them.
+		idempotency: newIdempotencyStore(idempotencyTTL, ""),
 	}
-	app.refreshPoster()
-	if app.posterErr != nil {
-		return nil, app.posterErr
+	app.refreshPosters()
+	if err := app.posterErrs[defaultAccountName]; err != nil {
+		return nil, err
 	}
 	return newRouter(app), nil
 }
@@ -175,17 +359,28 @@ func NewVercelHandler() (http.Handler, error) {
 func newRouter(app *App) *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
-	router.Use(gin.Logger(), gin.Recovery(), corsMiddleware())
+	router.Use(metricsMiddleware(), gin.Logger(), gin.Recovery(), corsMiddleware())
 
 	router.OPTIONS("/*any", func(c *gin.Context) {
 		c.Status(http.StatusNoContent)
 	})
 
 	protected := router.Group("/")
-	protected.Use(app.authMiddleware())
+	protected.Use(app.authMiddleware(), rateLimitHeaders())
 	{
-		protected.POST("/v1/tweets", app.handleCreateTweet)
-		protected.GET("/v1/timeline", app.handleGetTimeline)
+		protected.POST("/v1/tweets", requireScope(scopeTweetsWrite), app.handleCreateTweet)
+		protected.POST("/v1/tweets/validate", requireScope(scopeTweetsWrite), app.handleValidateTweet)
+		protected.DELETE("/v1/tweets/:id", requireScope(scopeTweetsWrite), app.handleDeleteTweet)
+		protected.GET("/v1/jobs/:id", requireScope(scopeTweetsWrite), app.handleGetJob)
+		protected.POST("/v1/media", requireScope(scopeMediaWrite), app.handleUploadMedia)
+		protected.POST("/v1/threads", requireScope(scopeTweetsWrite), app.handleCreateThread)
+		protected.GET("/v1/timeline", requireScope(scopeTimelineRead), app.handleGetTimeline)
+		protected.GET("/v1/scheduled", requireScope(scopeTweetsWrite), app.handleListScheduled)
+		protected.GET("/v1/outbox", requireScope(scopeTweetsWrite), app.handleListOutbox)
+		protected.DELETE("/v1/scheduled/:id", requireScope(scopeTweetsWrite), app.handleCancelScheduled)
+		if app.cfg.Server.Metrics && strings.TrimSpace(app.cfg.Server.MetricsAddr) == "" {
+			protected.GET("/metrics", requireScope(scopeMetricsRead), handleMetrics)
+		}
 	}
 
 	return router
@@ -194,8 +389,9 @@ func newRouter(app *App) *gin.Engine {
 func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "POST,OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Authorization,Content-Type,X-API-Token")
+		c.Header("Access-Control-Allow-Methods", "GET,POST,DELETE,OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Authorization,Content-Type,X-API-Token,Idempotency-Key")
+		c.Header("Access-Control-Expose-Headers", "X-Rate-Limit-Limit,X-Rate-Limit-Remaining,X-Rate-Limit-Reset,Retry-After,Idempotent-Replayed,Location")
 		if c.Request.Method == http.MethodOptions {
 			c.AbortWithStatus(http.StatusNoContent)
 			return
@@ -219,7 +415,9 @@ func loadOrInitConfig(path string) (*Config, bool, error) {
 	content, err := os.ReadFile(path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			cfg.Security.APIToken = generateToken()
+			if err := ensureAPITokenConfigured(&cfg.Security); err != nil {
+				return nil, false, err
+			}
 			if err := saveConfig(path, cfg); err != nil {
 				return nil, false, err
 			}
@@ -233,14 +431,26 @@ func loadOrInitConfig(path string) (*Config, bool, error) {
 			return nil, false, err
 		}
 	}
+	if err := cfg.openSecrets(); err != nil {
+		return nil, false, fmt.Errorf("failed to decrypt credentials: %w", err)
+	}
 
 	changed := false
 	if strings.TrimSpace(cfg.Server.Addr) == "" {
 		cfg.Server.Addr = defaultServerAddr
 		changed = true
 	}
-	if strings.TrimSpace(cfg.Security.APIToken) == "" {
-		cfg.Security.APIToken = generateToken()
+	migrated, err := migrateAPITokens(&cfg.Security)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to hash api tokens: %w", err)
+	}
+	if migrated {
+		changed = true
+	}
+	if len(cfg.Security.APITokens) == 0 && strings.TrimSpace(os.Getenv("XPOST_API_TOKEN")) == "" {
+		if err := ensureAPITokenConfigured(&cfg.Security); err != nil {
+			return nil, false, err
+		}
 		changed = true
 	}
 
@@ -253,17 +463,51 @@ func loadOrInitConfig(path string) (*Config, bool, error) {
 	return cfg, false, nil
 }
 
-func saveConfig(path string, cfg *Config) error {
-	data, err := json.MarshalIndent(cfg, "", "  ")
+// ensureAPITokenConfigured generates a full-access token when none exists.
+// Only its hash is kept; the plaintext is left in generatedToken so the caller
+// can show it once. Nothing is generated when XPOST_API_TOKEN is set.
+func ensureAPITokenConfigured(s *SecurityConfig) error {
+	if len(s.APITokens) > 0 || strings.TrimSpace(os.Getenv("XPOST_API_TOKEN")) != "" {
+		return nil
+	}
+	plain, token, err := newHashedAPIToken(legacyTokenID, "api_token", []string{scopeAll})
+	if err != nil {
+		return fmt.Errorf("failed to generate api token: %w", err)
+	}
+	s.APITokens = append(s.APITokens, token)
+	s.generatedToken = plain
+	return nil
+}
+
+// updateConfigFile loads the config on disk under its lock file, runs fn on
+// it and writes it back. Unlike saveConfig it keeps whatever another process
+// wrote since this one loaded the config.
+func updateConfigFile(path string, fn func(cfg *Config) error) error {
+	unlock, err := acquireFileLock(path + ".lock")
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	tmpPath := path + ".tmp"
-	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+	var disk Config
+	if err := readJSONFile(path, &disk); err != nil {
 		return err
 	}
-	return os.Rename(tmpPath, path)
+	if err := disk.openSecrets(); err != nil {
+		return fmt.Errorf("failed to decrypt credentials: %w", err)
+	}
+	if err := fn(&disk); err != nil {
+		return err
+	}
+	return saveConfig(path, &disk)
+}
+
+func saveConfig(path string, cfg *Config) error {
+	out, err := cfg.sealedCopy()
+	if err != nil {
+		return fmt.Errorf("failed to encrypt credentials: %w", err)
+	}
+	return writeJSONFile(path, out)
 }
 
 func overrideConfigFromEnv(cfg *Config) {
@@ -271,7 +515,36 @@ func overrideConfigFromEnv(cfg *Config) {
 		cfg.Server.Addr = v
 	}
 	if v := strings.TrimSpace(os.Getenv("XPOST_API_TOKEN")); v != "" {
-		cfg.Security.APIToken = v
+		cfg.Security.envToken = v
+	}
+	if v := strings.TrimSpace(os.Getenv("XPOST_IDEMPOTENCY_TTL")); v != "" {
+		cfg.Server.IdempotencyTTL = v
+	}
+	if v := strings.TrimSpace(os.Getenv("XPOST_OUTBOX")); v != "" {
+		if enabled, err := strconv.ParseBool(v); err == nil {
+			cfg.Server.Outbox = enabled
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("XPOST_METRICS")); v != "" {
+		if enabled, err := strconv.ParseBool(v); err == nil {
+			cfg.Server.Metrics = enabled
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("XPOST_METRICS_ADDR")); v != "" {
+		cfg.Server.MetricsAddr = v
+	}
+	if v := strings.TrimSpace(os.Getenv("XPOST_MEDIA_URL_ALLOW_NETWORKS")); v != "" {
+		cfg.Server.MediaURLAllowNetworks = splitCSV(v)
+	}
+	if v := strings.TrimSpace(os.Getenv("XPOST_IMAGE_PREPROCESS")); v != "" {
+		if enabled, err := strconv.ParseBool(v); err == nil {
+			cfg.imageConfig().Disable = !enabled
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("XPOST_IMAGE_QUALITY")); v != "" {
+		if quality, err := strconv.Atoi(v); err == nil {
+			cfg.imageConfig().JPEGQuality = quality
+		}
 	}
 
 	if v := strings.TrimSpace(os.Getenv("X_API_KEY")); v != "" {
@@ -335,15 +608,22 @@ func ensureFirstBootAuthConfigured(cfg XAuthConfig) error {
 	return errors.New("set OAuth1 credentials via X_API_KEY/X_API_SECRET/X_ACCESS_TOKEN/X_ACCESS_TOKEN_SECRET, or set X_OAUTH2_ACCESS_TOKEN")
 }
 
-func (a *App) refreshPoster() {
+func (a *App) refreshPosters() {
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	poster, err := newPoster(a.cfg.X)
-	a.poster = poster
-	a.posterErr = err
+	a.posters = make(map[string]*Poster)
+	a.posterErrs = make(map[string]error)
+	for _, name := range a.cfg.accountNames() {
+		poster, err := newAccountPoster(a.cfg, name)
+		if err != nil {
+			a.posterErrs[name] = err
+			continue
+		}
+		a.posters[name] = poster
+	}
 }
 
-func newPoster(authCfg XAuthConfig) (*Poster, error) {
+func newPoster(account string, authCfg XAuthConfig) (*Poster, error) {
 	if hasAnyOAuth1Fields(authCfg) {
 		if missing := missingOAuth1Fields(authCfg); len(missing) > 0 {
 			return nil, fmt.Errorf("incomplete OAuth1 config, missing: %s", strings.Join(missing, ", "))
@@ -356,13 +636,14 @@ func newPoster(authCfg XAuthConfig) (*Poster, error) {
 			authCfg.AccessToken,
 			authCfg.AccessTokenSecret,
 		)
-		client := xdk.NewClient(xdk.Config{Auth: oauth1})
-		return &Poster{client: client, authMode: "oauth1"}, nil
+		client := xdk.NewClient(xdk.Config{Auth: oauth1, HTTPClient: newXHTTPClient(account)})
+		return &Poster{client: client, authMode: "oauth1", account: account}, nil
 	}
 
 	if strings.TrimSpace(authCfg.OAuth2AccessToken) != "" {
 		clientCfg := xdk.Config{
 			AccessToken: authCfg.OAuth2AccessToken,
+			HTTPClient:  newXHTTPClient(account),
 		}
 		if strings.TrimSpace(authCfg.OAuth2ClientID) != "" {
 			clientCfg.ClientID = strings.TrimSpace(authCfg.OAuth2ClientID)
@@ -372,7 +653,7 @@ func newPoster(authCfg XAuthConfig) (*Poster, error) {
 			clientCfg.Token = oauth2TokenFromConfig(authCfg)
 		}
 		client := xdk.NewClient(clientCfg)
-		return &Poster{client: client, authMode: "oauth2_user_token"}, nil
+		return &Poster{client: client, authMode: "oauth2_user_token", account: account}, nil
 	}
 
 	return nil, errors.New("missing x auth configuration (set OAuth1 fields or oauth2_access_token)")
@@ -402,27 +683,6 @@ func missingOAuth1Fields(cfg XAuthConfig) []string {
 	return missing
 }
 
-func (a *App) authMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		expected := a.getAPIToken()
-		if expected == "" {
-			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
-				"error": "api token is not configured",
-			})
-			return
-		}
-
-		got := readTokenFromRequest(c.Request)
-		if subtle.ConstantTimeCompare([]byte(got), []byte(expected)) != 1 {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid api token",
-			})
-			return
-		}
-		c.Next()
-	}
-}
-
 func readTokenFromRequest(r *http.Request) string {
 	authHeader := strings.TrimSpace(r.Header.Get("Authorization"))
 	if len(authHeader) > 7 && strings.EqualFold(authHeader[:7], "Bearer ") {
@@ -431,22 +691,20 @@ func readTokenFromRequest(r *http.Request) string {
 	return strings.TrimSpace(r.Header.Get("X-API-Token"))
 }
 
-func (a *App) getAPIToken() string {
+func (a *App) getPoster(account string) (*Poster, error) {
 	a.mu.RLock()
 	defer a.mu.RUnlock()
-	return a.cfg.Security.APIToken
-}
-
-func (a *App) getPoster() (*Poster, error) {
-	a.mu.RLock()
-	defer a.mu.RUnlock()
-	if a.poster == nil {
-		if a.posterErr != nil {
-			return nil, a.posterErr
-		}
-		return nil, errors.New("x client is not ready")
+	_, name, err := a.cfg.accountAuth(account)
+	if err != nil {
+		return nil, err
 	}
-	return a.poster, nil
+	if poster := a.posters[name]; poster != nil {
+		return poster, nil
+	}
+	if err := a.posterErrs[name]; err != nil {
+		return nil, err
+	}
+	return nil, errors.New("x client is not ready")
 }
 
 func (a *App) persistConfig(cfg *Config) error {
@@ -462,66 +720,182 @@ func (a *App) persistOAuth2Token(poster *Poster) {
 	}
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	if err := persistOAuth2TokenIfAvailable(a.cfg, a.configPath, poster.client); err != nil {
+	if err := persistOAuth2TokenIfAvailable(a.cfg, a.configPath, poster.account, poster.client); err != nil {
 		log.Printf("warning: failed to persist refreshed oauth2 token: %v", err)
 	}
+	// Pick up api tokens the CLI changed before this write, which would
+	// otherwise go unnoticed once cfgModTime moves past them.
+	if err := a.reloadAPITokensLocked(); err != nil {
+		log.Printf("warning: failed to reload api tokens: %v", err)
+	}
+	if info, err := os.Stat(a.configPath); err == nil {
+		a.cfgModTime = info.ModTime()
+	}
 }
 
 func (a *App) handleCreateTweet(c *gin.Context) {
-	poster, err := a.getPoster()
+	req, err := parseTweetRequest(c)
 	if err != nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		c.JSON(errorResponse(err, http.StatusBadRequest, codeValidationFailed))
 		return
 	}
+	defer func() { releaseMedia(req.Media) }()
 
-	text, mediaInputs, replyToTweetID, err := parseTweetRequest(c)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	finish, ok := a.idempotency.begin(c, req.fingerprint)
+	if !ok {
 		return
 	}
+	defer finish()
 
-	ctx, cancel := context.WithTimeout(c.Request.Context(), 90*time.Second)
-	defer cancel()
+	if (len(req.Media) > 0 || len(req.MediaURLs) > 0) && !checkScope(c, scopeMediaWrite) {
+		return
+	}
 
-	uploaded := make([]MediaRef, 0, len(mediaInputs))
-	for _, input := range mediaInputs {
-		ref, err := poster.UploadMedia(ctx, input.Data, input.ContentType)
-		if err != nil {
-			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+	if req.async && req.PublishAt.IsZero() {
+		if a.outbox != nil {
+			// Posts already leave through the outbox in the background;
+			// there is no job to poll.
+			c.JSON(http.StatusBadRequest, errorBody(codeNotSupported, "async is not supported in outbox mode, poll GET /v1/outbox instead"))
 			return
 		}
-		uploaded = append(uploaded, ref)
+		// The job downloads and prepares the media itself, so the 202 does
+		// not wait for it.
+		poster, ok := a.posterForRequest(c, req.Account)
+		if !ok {
+			return
+		}
+		a.handleAsyncTweet(c, poster, &req)
+		return
+	}
+
+	// media_urls are only downloaded once the token is known to be allowed
+	// to post media.
+	if err := a.loadMedia(c.Request.Context(), &req); err != nil {
+		c.JSON(errorResponse(err, http.StatusBadRequest, codeValidationFailed))
+		return
+	}
+
+	if !req.PublishAt.IsZero() {
+		a.handleScheduleTweet(c, req)
+		return
 	}
 
-	tweetResp, err := poster.CreateTweet(ctx, text, uploaded, replyToTweetID)
+	if a.outbox != nil {
+		a.handleOutboxTweet(c, req)
+		return
+	}
+
+	poster, ok := a.posterForRequest(c, req.Account)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if idempotencyClaimed(c) {
+		// A client that gives up must not cancel a post X may already have
+		// made; the outcome is stored for its retry instead.
+		ctx = context.WithoutCancel(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, uploadTimeout(req.Media))
+	defer cancel()
+
+	status, body := a.publishTweet(ctx, poster, req)
+	if posted, _ := body["may_have_posted"].(bool); posted {
+		keepIdempotentResponse(c)
+	}
+	c.JSON(status, body)
+}
+
+// loadMedia downloads the media_urls of req and prepares all of its media
+// for upload.
+func (a *App) loadMedia(ctx context.Context, req *tweetRequest) error {
+	fetched, err := a.fetcher.fetchAll(ctx, req.MediaURLs)
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return err
+	}
+	req.Media = append(req.Media, fetched...)
+	return prepareMedia(req.Media, a.imageConfig())
+}
+
+// handleAsyncTweet queues req and answers 202 with the job. The job takes
+// over req.Media, downloads media_urls and prepares the media while it is
+// uploading, and removes the files when it is done.
+func (a *App) handleAsyncTweet(c *gin.Context, poster *Poster, req *tweetRequest) {
+	if a.jobs == nil {
+		c.JSON(http.StatusBadRequest, errorBody(codeNotSupported, "async is not supported by this server"))
 		return
 	}
+	timeout := uploadTimeout(req.Media)
+	if n := len(req.MediaURLs); n > 0 {
+		// The downloads may well be videos.
+		timeout = mediaPostTimeout + time.Duration(n)*mediaFetchTimeout
+	}
+	job := *req
+	queued, err := a.jobs.submit(poster.account, timeout, func(ctx context.Context) (int, gin.H) {
+		if err := a.loadMedia(ctx, &job); err != nil {
+			return errorResponse(err, http.StatusBadRequest, codeValidationFailed)
+		}
+		return a.publishTweet(ctx, poster, job)
+	}, func() { releaseMedia(job.Media) })
+	req.Media = nil
+	if err != nil {
+		c.JSON(errorResponse(err, http.StatusServiceUnavailable, codeQueueFull))
+		return
+	}
+	c.Header("Location", "/v1/jobs/"+queued.ID)
+	c.JSON(http.StatusAccepted, gin.H{"ok": true, "job": queued})
+}
+
+// publishTweet uploads the media of req and creates the post, or the whole
+// reply chain when auto_thread split the text. It returns the response
+// status and body.
+func (a *App) publishTweet(ctx context.Context, poster *Poster, req tweetRequest) (int, gin.H) {
+	if len(req.threadParts) > 0 {
+		result, err := postThread(ctx, poster, a.library, autoThreadItems(req), req.ReplyToTweetID)
+		a.persistOAuth2Token(poster)
+		return threadResultBody(poster, result, err)
+	}
+
+	uploaded, err := uploadMediaInputs(ctx, poster, a.library, req.Media)
+	if err != nil {
+		return errorResponse(err, http.StatusBadGateway, codeXError)
+	}
+	uploaded = append(uploaded, mediaRefsFromIDs(req.MediaIDs)...)
+
+	tweetResp, err := poster.CreateTweet(ctx, req.Text, uploaded, req.ReplyToTweetID, req.tweetOptions)
+	if err != nil {
+		status, body := errorResponse(err, http.StatusBadGateway, codeXError)
+		if mayHavePosted(err) {
+			body["may_have_posted"] = true
+		}
+		return status, body
+	}
 
 	a.persistOAuth2Token(poster)
 
-	c.JSON(http.StatusOK, gin.H{
+	return http.StatusOK, gin.H{
 		"ok":          true,
+		"account":     poster.account,
 		"auth_mode":   poster.authMode,
 		"media":       uploaded,
 		"tweet":       tweetResp,
 		"media_count": len(uploaded),
-	})
+	}
 }
 
 func (a *App) handleGetTimeline(c *gin.Context) {
-	poster, err := a.getPoster()
-	if err != nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	poster, ok := a.posterForRequest(c, requestAccount(c, c.Query("account")))
+	if !ok {
 		return
 	}
 
-	a.mu.RLock()
-	userID := strings.TrimSpace(a.cfg.X.UserID)
-	a.mu.RUnlock()
+	userID := a.accountUserID(poster.account)
 	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "X_USER_ID is not configured"})
+		msg := "X_USER_ID is not configured"
+		if poster.account != defaultAccountName {
+			msg = fmt.Sprintf("user_id is not configured for account %s", poster.account)
+		}
+		c.JSON(http.StatusBadRequest, errorBody(codeNotConfigured, msg))
 		return
 	}
 
@@ -548,7 +922,7 @@ func (a *App) handleGetTimeline(c *gin.Context) {
 
 	timeline, err := poster.GetTimeline(ctx, params)
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		c.JSON(errorResponse(err, http.StatusBadGateway, codeXError))
 		return
 	}
 
@@ -557,302 +931,332 @@ func (a *App) handleGetTimeline(c *gin.Context) {
 	c.JSON(http.StatusOK, timeline)
 }
 
-func parseTweetRequest(c *gin.Context) (string, []mediaUploadInput, string, error) {
+func parseTweetRequest(c *gin.Context) (tweetRequest, error) {
+	var req tweetRequest
+	var err error
 	contentType := c.GetHeader("Content-Type")
 	if strings.HasPrefix(contentType, "multipart/form-data") {
-		return parseMultipartTweetRequest(c)
+		req, err = parseMultipartTweetRequest(c)
+	} else {
+		req, err = parseJSONTweetRequest(c)
 	}
-	return parseJSONTweetRequest(c)
-}
-
-func parseMultipartTweetRequest(c *gin.Context) (string, []mediaUploadInput, string, error) {
-	text := strings.TrimSpace(c.PostForm("text"))
-	replyToTweetID := strings.TrimSpace(c.PostForm("reply_to_tweet_id"))
-
-	form, err := c.MultipartForm()
 	if err != nil {
-		return "", nil, "", fmt.Errorf("invalid multipart request: %w", err)
-	}
-
-	files := form.File["media"]
-	if len(files) > maxMediaCount {
-		return "", nil, "", fmt.Errorf("too many media files, max is %d", maxMediaCount)
-	}
-	if text == "" && len(files) == 0 {
-		return "", nil, "", errors.New("text or media is required")
+		return tweetRequest{}, err
 	}
-
-	media := make([]mediaUploadInput, 0, len(files))
-	for _, fh := range files {
-		f, err := fh.Open()
+	if v := c.Query("async"); v != "" {
+		async, err := strconv.ParseBool(v)
 		if err != nil {
-			return "", nil, "", err
-		}
-
-		data, readErr := io.ReadAll(io.LimitReader(f, maxMediaBytes+1))
-		closeErr := f.Close()
-		if readErr != nil {
-			return "", nil, "", readErr
-		}
-		if closeErr != nil {
-			return "", nil, "", closeErr
+			releaseMedia(req.Media)
+			return tweetRequest{}, fmt.Errorf("invalid async %q", v)
 		}
-		if int64(len(data)) > maxMediaBytes {
-			return "", nil, "", fmt.Errorf("file %q exceeds max size %d bytes", fh.Filename, maxMediaBytes)
-		}
-
-		contentType := fh.Header.Get("Content-Type")
-		if strings.TrimSpace(contentType) == "" {
-			contentType = http.DetectContentType(data)
-		}
-
-		media = append(media, mediaUploadInput{
-			Data:        data,
-			ContentType: contentType,
-		})
+		req.async = req.async || async
 	}
-
-	return text, media, replyToTweetID, nil
+	return req, nil
 }
 
-func parseJSONTweetRequest(c *gin.Context) (string, []mediaUploadInput, string, error) {
-	var req createTweetJSONRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		return "", nil, "", err
-	}
-
-	text := strings.TrimSpace(req.Text)
-	if text == "" && len(req.MediaBase64) == 0 {
-		return "", nil, "", errors.New("text or media_base64 is required")
+// parseMultipartTweetRequest streams the form part by part so uploaded files
+// go straight to temp files instead of being held in memory.
+func parseMultipartTweetRequest(c *gin.Context) (tweetRequest, error) {
+	values, media, err := readMultipartForm(c)
+	if err != nil {
+		return tweetRequest{}, err
 	}
-	if len(req.MediaBase64) > maxMediaCount {
-		return "", nil, "", fmt.Errorf("too many media items, max is %d", maxMediaCount)
+	req, err := tweetRequestFromForm(c, values, media)
+	if err != nil {
+		releaseMedia(media)
+		return tweetRequest{}, err
 	}
-	if len(req.MediaContentTypes) > 0 && len(req.MediaContentTypes) != len(req.MediaBase64) {
-		return "", nil, "", errors.New("media_content_types length must match media_base64 length")
+	return req, nil
+}
+
+func readMultipartForm(c *gin.Context) (url.Values, []mediaUploadInput, error) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid multipart request: %w", err)
 	}
 
-	media := make([]mediaUploadInput, 0, len(req.MediaBase64))
-	for i, item := range req.MediaBase64 {
-		raw := strings.TrimSpace(item)
-		if raw == "" {
-			return "", nil, "", fmt.Errorf("media_base64[%d] is empty", i)
+	values := url.Values{}
+	var media []mediaUploadInput
+	fail := func(err error) (url.Values, []mediaUploadInput, error) {
+		releaseMedia(media)
+		return nil, nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return values, media, nil
 		}
-		data, err := base64.StdEncoding.DecodeString(raw)
 		if err != nil {
-			return "", nil, "", fmt.Errorf("media_base64[%d] decode failed: %w", i, err)
-		}
-		if int64(len(data)) > maxMediaBytes {
-			return "", nil, "", fmt.Errorf("media_base64[%d] exceeds max size %d bytes", i, maxMediaBytes)
+			return fail(fmt.Errorf("invalid multipart request: %w", err))
 		}
 
-		contentType := ""
-		if len(req.MediaContentTypes) > 0 && strings.TrimSpace(req.MediaContentTypes[i]) != "" {
-			contentType = strings.TrimSpace(req.MediaContentTypes[i])
-		} else {
-			contentType = http.DetectContentType(data)
+		if part.FileName() != "" {
+			if part.FormName() != "media" {
+				continue
+			}
+			if len(media) == maxMediaCount {
+				return fail(fmt.Errorf("too many media files, max is %d", maxMediaCount))
+			}
+			input, err := spoolMedia(fmt.Sprintf("file %q", part.FileName()), part, part.Header.Get("Content-Type"))
+			if err != nil {
+				return fail(err)
+			}
+			media = append(media, input)
+			continue
 		}
 
-		media = append(media, mediaUploadInput{
-			Data:        data,
-			ContentType: contentType,
-		})
-	}
-
-	return text, media, strings.TrimSpace(req.ReplyToTweetID), nil
-}
-
-func (p *Poster) UploadMedia(ctx context.Context, data []byte, contentType string) (MediaRef, error) {
-	encoded := base64.StdEncoding.EncodeToString(data)
-	mediaCategory := mediaCategoryFromType(contentType)
-	attemptBodies := []map[string]any{
-		{
-			"media":          encoded,
-			"media_type":     contentType,
-			"media_category": mediaCategory,
-		},
-		{
-			"media_data":     encoded,
-			"media_type":     contentType,
-			"media_category": mediaCategory,
-		},
-	}
-
-	var errs []string
-	for _, body := range attemptBodies {
-		resp, err := p.client.Media.Upload(ctx, xdk.Params{"body": body})
+		value, err := io.ReadAll(io.LimitReader(part, maxFormValueBytes+1))
 		if err != nil {
-			errs = append(errs, err.Error())
-			continue
+			return fail(fmt.Errorf("invalid multipart request: %w", err))
 		}
-		ref := extractMediaRef(resp)
-		if ref.ID != "" || ref.MediaKey != "" {
-			return ref, nil
+		if len(value) > maxFormValueBytes {
+			return fail(fmt.Errorf("form field %q is too large", part.FormName()))
 		}
-		errs = append(errs, "upload returned no media identifier")
+		values.Add(part.FormName(), string(value))
 	}
+}
 
-	ref, err := p.uploadMediaChunked(ctx, encoded, len(data), contentType)
-	if err == nil {
-		return ref, nil
+func tweetRequestFromForm(c *gin.Context, values url.Values, media []mediaUploadInput) (tweetRequest, error) {
+	req := tweetRequest{
+		Text:           strings.TrimSpace(values.Get("text")),
+		Media:          media,
+		ReplyToTweetID: strings.TrimSpace(values.Get("reply_to_tweet_id")),
+		Account:        requestAccount(c, values.Get("account")),
 	}
-	errs = append(errs, err.Error())
-
-	if p.client != nil && p.client.Auth != nil {
-		ref, err = p.uploadMediaV1(ctx, data, contentType)
-		if err == nil {
-			return ref, nil
-		}
-		errs = append(errs, err.Error())
+	mediaURLs := values["media_urls"]
+	mediaIDs, err := parseMediaIDs(values["media_ids"])
+	if err != nil {
+		return tweetRequest{}, err
 	}
-
-	return MediaRef{}, fmt.Errorf("media upload failed: %s", strings.Join(errs, " | "))
-}
-
-func (p *Poster) uploadMediaChunked(ctx context.Context, encoded string, size int, contentType string) (MediaRef, error) {
-	initResp, err := p.client.Media.InitializeUpload(ctx, xdk.Params{
-		"body": map[string]any{
-			"total_bytes":    size,
-			"media_type":     contentType,
-			"media_category": mediaCategoryFromType(contentType),
-		},
-	})
+	req.MediaIDs = mediaIDs
+	mediaCount := len(media) + len(mediaURLs) + len(mediaIDs)
+	if req.Text == "" && mediaCount == 0 {
+		return tweetRequest{}, errors.New("text or media is required")
+	}
+	autoThread, err := parseFormBool(values, "auto_thread")
 	if err != nil {
-		return MediaRef{}, err
+		return tweetRequest{}, err
 	}
-
-	initRef := extractMediaRef(initResp)
-	mediaID := initRef.ID
-	if mediaID == "" {
-		return MediaRef{}, errors.New("initialize_upload did not return media id")
+	numbered, err := parseFormBool(values, "auto_thread_numbering")
+	if err != nil {
+		return tweetRequest{}, err
 	}
-
-	appendBodies := []map[string]any{
-		{
-			"segment_index": 0,
-			"media":         encoded,
-		},
-		{
-			"segment_index": 0,
-			"media_data":    encoded,
-		},
+	if req.threadParts, err = autoThreadText(req.Text, autoThread, numbered); err != nil {
+		return tweetRequest{}, err
+	}
+	if req.async, err = parseFormBool(values, "async"); err != nil {
+		return tweetRequest{}, err
+	}
+	if mediaCount > maxMediaCount {
+		return tweetRequest{}, fmt.Errorf("too many media items, max is %d", maxMediaCount)
 	}
 
-	var appendErr error
-	for _, body := range appendBodies {
-		_, appendErr = p.client.Media.AppendUpload(ctx, xdk.Params{
-			"id":   mediaID,
-			"body": body,
-		})
-		if appendErr == nil {
-			break
-		}
+	// alt_text fields cover the uploaded files first, then media_urls.
+	altTexts := values["alt_text"]
+	if len(altTexts) > len(media)+len(mediaURLs) {
+		return tweetRequest{}, fmt.Errorf("got %d alt texts for %d media items", len(altTexts), len(media)+len(mediaURLs))
+	}
+	fileAlts := altTexts[:min(len(altTexts), len(media))]
+	if err := setAltTexts(media, fileAlts); err != nil {
+		return tweetRequest{}, err
 	}
-	if appendErr != nil {
-		return MediaRef{}, appendErr
+	urls, err := parseMediaURLs(mediaURLs, altTexts[len(fileAlts):])
+	if err != nil {
+		return tweetRequest{}, err
 	}
+	req.MediaURLs = urls
 
-	finalResp, err := p.client.Media.FinalizeUpload(ctx, xdk.Params{
-		"id": mediaID,
-	})
+	publishAt, err := parsePublishAt(values.Get("publish_at"))
 	if err != nil {
-		return MediaRef{}, err
+		return tweetRequest{}, err
 	}
+	if len(req.threadParts) > 0 && !publishAt.IsZero() {
+		return tweetRequest{}, errAutoThreadScheduled
+	}
+	req.PublishAt = publishAt
 
-	finalRef := extractMediaRef(finalResp)
-	if finalRef.ID == "" {
-		finalRef.ID = mediaID
+	req.QuoteTweetID = values.Get("quote_tweet_id")
+	req.ReplySettings = values.Get("reply_settings")
+	for _, v := range values["exclude_reply_user_ids"] {
+		req.ExcludeReplyUserIDs = append(req.ExcludeReplyUserIDs, splitCSV(v)...)
+	}
+	if req.ForSuperFollowersOnly, err = parseFormBool(values, "for_super_followers_only"); err != nil {
+		return tweetRequest{}, err
 	}
-	if finalRef.MediaKey == "" {
-		finalRef.MediaKey = initRef.MediaKey
+	if options := values["poll_options"]; len(options) > 0 {
+		minutes, err := strconv.Atoi(strings.TrimSpace(values.Get("poll_duration_minutes")))
+		if err != nil {
+			return tweetRequest{}, errors.New("poll_duration_minutes is required with poll_options")
+		}
+		req.Poll = &tweetPoll{Options: options, DurationMinutes: minutes}
+	}
+	if err := req.tweetOptions.normalize(req.ReplyToTweetID, mediaCount); err != nil {
+		return tweetRequest{}, err
 	}
-	return finalRef, nil
+
+	return req, nil
 }
 
-func (p *Poster) uploadMediaV1(ctx context.Context, data []byte, contentType string) (MediaRef, error) {
-	const uploadURL = "https://upload.twitter.com/1.1/media/upload.json"
-	if p.client == nil || p.client.Auth == nil {
-		return MediaRef{}, errors.New("oauth1 auth is required for v1 media upload fallback")
+func parseJSONTweetRequest(c *gin.Context) (tweetRequest, error) {
+	var body createTweetJSONRequest
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxJSONBodyBytes)
+	if err := c.ShouldBindJSON(&body); err != nil {
+		return tweetRequest{}, err
 	}
 
-	var body bytes.Buffer
-	writer := multipart.NewWriter(&body)
-	part, err := writer.CreateFormFile("media", "upload")
+	text := strings.TrimSpace(body.Text)
+	mediaIDs, err := parseMediaIDs(body.MediaIDs)
 	if err != nil {
-		return MediaRef{}, err
+		return tweetRequest{}, err
 	}
-	if _, err := part.Write(data); err != nil {
-		return MediaRef{}, err
+	uploadCount := len(body.MediaBase64) + len(body.MediaURLs)
+	mediaCount := uploadCount + len(mediaIDs)
+	if text == "" && mediaCount == 0 {
+		return tweetRequest{}, errors.New("text, media_base64, media_urls or media_ids is required")
 	}
-	if strings.TrimSpace(contentType) != "" {
-		_ = writer.WriteField("media_type", contentType)
+	threadParts, err := autoThreadText(text, body.AutoThread, body.AutoThreadNumbering)
+	if err != nil {
+		return tweetRequest{}, err
 	}
-	if err := writer.Close(); err != nil {
-		return MediaRef{}, err
+	if mediaCount > maxMediaCount {
+		return tweetRequest{}, fmt.Errorf("too many media items, max is %d", maxMediaCount)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, &body)
+	publishAt, err := parsePublishAt(body.PublishAt)
 	if err != nil {
-		return MediaRef{}, err
+		return tweetRequest{}, err
+	}
+	if len(threadParts) > 0 && !publishAt.IsZero() {
+		return tweetRequest{}, errAutoThreadScheduled
 	}
-	req.Header.Set("Content-Type", writer.FormDataContentType())
 
-	authHeader, err := p.client.Auth.BuildRequestHeader(http.MethodPost, uploadURL, "")
-	if err != nil {
-		return MediaRef{}, err
+	replyTo := strings.TrimSpace(body.ReplyToTweetID)
+	if err := body.tweetOptions.normalize(replyTo, mediaCount); err != nil {
+		return tweetRequest{}, err
 	}
-	req.Header.Set("Authorization", authHeader)
 
-	resp, err := http.DefaultClient.Do(req)
+	// alt_text covers media_base64 first, then media_urls.
+	var base64Alts, urlAlts []string
+	if len(body.AltText) > 0 {
+		if len(body.AltText) != uploadCount {
+			return tweetRequest{}, errors.New("alt_text length must match the number of media items")
+		}
+		base64Alts, urlAlts = body.AltText[:len(body.MediaBase64)], body.AltText[len(body.MediaBase64):]
+	}
+	urls, err := parseMediaURLs(body.MediaURLs, urlAlts)
 	if err != nil {
-		return MediaRef{}, err
+		return tweetRequest{}, err
 	}
-	defer resp.Body.Close()
 
-	payload, err := io.ReadAll(io.LimitReader(resp.Body, 4*1024*1024))
+	media, err := decodeBase64Media(body.MediaBase64, body.MediaContentTypes, base64Alts)
 	if err != nil {
-		return MediaRef{}, err
+		return tweetRequest{}, err
+	}
+
+	return tweetRequest{
+		Text:           text,
+		Media:          media,
+		MediaURLs:      urls,
+		MediaIDs:       mediaIDs,
+		ReplyToTweetID: replyTo,
+		Account:        requestAccount(c, body.Account),
+		PublishAt:      publishAt,
+		tweetOptions:   body.tweetOptions,
+		threadParts:    threadParts,
+		async:          body.Async,
+	}, nil
+}
+
+var errAutoThreadScheduled = errors.New("auto_thread cannot be combined with publish_at")
+
+func parseFormBool(values url.Values, key string) (bool, error) {
+	v := strings.TrimSpace(values.Get(key))
+	if v == "" {
+		return false, nil
 	}
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return MediaRef{}, fmt.Errorf("v1 media upload failed: status=%d body=%s", resp.StatusCode, strings.TrimSpace(string(payload)))
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q", key, v)
 	}
+	return b, nil
+}
 
-	var obj any
-	decoder := json.NewDecoder(bytes.NewReader(payload))
-	decoder.UseNumber()
-	if err := decoder.Decode(&obj); err != nil {
-		return MediaRef{}, fmt.Errorf("v1 media upload parse failed: %w", err)
+// decodeBase64Media decodes each item straight into a spool file. On error
+// the files already written are removed.
+func decodeBase64Media(items []string, contentTypes []string, altTexts []string) ([]mediaUploadInput, error) {
+	if len(contentTypes) > 0 && len(contentTypes) != len(items) {
+		return nil, errors.New("media_content_types length must match media_base64 length")
 	}
-	ref := extractMediaRef(obj)
-	if ref.ID == "" {
-		return MediaRef{}, fmt.Errorf("v1 media upload returned no media id: %s", strings.TrimSpace(string(payload)))
+	if len(altTexts) > 0 && len(altTexts) != len(items) {
+		return nil, errors.New("alt_text length must match media_base64 length")
+	}
+	// Alt texts are checked before anything is decoded.
+	if _, err := normalizeAltTexts(altTexts, len(items)); err != nil {
+		return nil, err
 	}
-	return ref, nil
-}
 
-func mediaCategoryFromType(contentType string) string {
-	ct := strings.ToLower(strings.TrimSpace(contentType))
-	switch {
-	case strings.HasPrefix(ct, "image/"):
-		return "tweet_image"
-	case strings.HasPrefix(ct, "video/"):
-		return "tweet_video"
-	case strings.HasPrefix(ct, "audio/"):
-		return "tweet_video"
-	default:
-		return "tweet_image"
+	media := make([]mediaUploadInput, 0, len(items))
+	for i, item := range items {
+		raw := strings.TrimSpace(item)
+		if raw == "" {
+			releaseMedia(media)
+			return nil, fmt.Errorf("media_base64[%d] is empty", i)
+		}
+
+		contentType := ""
+		if len(contentTypes) > 0 {
+			contentType = contentTypes[i]
+		}
+		name := fmt.Sprintf("media_base64[%d]", i)
+		input, err := spoolMedia(name, base64.NewDecoder(base64.StdEncoding, strings.NewReader(raw)), contentType)
+		if err != nil {
+			releaseMedia(media)
+			var corrupt base64.CorruptInputError
+			if errors.As(err, &corrupt) {
+				return nil, fmt.Errorf("%s decode failed: %w", name, corrupt)
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, fmt.Errorf("%s decode failed: truncated base64 data", name)
+			}
+			return nil, err
+		}
+		media = append(media, input)
+	}
+	if err := setAltTexts(media, altTexts); err != nil {
+		releaseMedia(media)
+		return nil, err
 	}
+	return media, nil
 }
 
-func (p *Poster) CreateTweet(ctx context.Context, text string, media []MediaRef, replyToTweetID string) (xdk.JSON, error) {
+func (p *Poster) CreateTweet(ctx context.Context, text string, media []MediaRef, replyToTweetID string, opts tweetOptions) (xdk.JSON, error) {
 	body := map[string]any{}
 	if strings.TrimSpace(text) != "" {
 		body["text"] = strings.TrimSpace(text)
 	}
 	if strings.TrimSpace(replyToTweetID) != "" {
-		body["reply"] = map[string]any{
+		reply := map[string]any{
 			"in_reply_to_tweet_id": strings.TrimSpace(replyToTweetID),
 		}
+		if len(opts.ExcludeReplyUserIDs) > 0 {
+			reply["exclude_reply_user_ids"] = opts.ExcludeReplyUserIDs
+		}
+		body["reply"] = reply
+	}
+	if opts.QuoteTweetID != "" {
+		body["quote_tweet_id"] = opts.QuoteTweetID
+	}
+	if opts.ReplySettings != "" {
+		body["reply_settings"] = opts.ReplySettings
+	}
+	if opts.ForSuperFollowersOnly {
+		body["for_super_followers_only"] = true
+	}
+	if opts.Poll != nil {
+		body["poll"] = map[string]any{
+			"options":          opts.Poll.Options,
+			"duration_minutes": opts.Poll.DurationMinutes,
+		}
 	}
 
 	mediaIDs := uniqueNonEmpty(mediaIDs(media))
@@ -863,31 +1267,45 @@ func (p *Poster) CreateTweet(ctx context.Context, text string, media []MediaRef,
 		}
 	}
 
-	resp, err := p.client.Posts.Create(ctx, xdk.Params{"body": body})
+	create := func(ctx context.Context) (xdk.JSON, error) {
+		return p.client.Posts.Create(ctx, xdk.Params{"body": body})
+	}
+	resp, err := withRetry(ctx, retryUnsent, create)
 	if err == nil {
+		metricPostsCreated.inc(p.account, p.authMode)
 		return resp, nil
 	}
 
-	if len(mediaKeys) > 0 {
+	// Fall back to media keys only when X rejected the request outright, so
+	// the first attempt cannot have created a post.
+	var apiErr *xdk.APIError
+	rejected := errors.As(err, &apiErr) && apiErr.StatusCode >= 400 && apiErr.StatusCode < 500 && apiErr.StatusCode != http.StatusTooManyRequests
+	if len(mediaKeys) > 0 && rejected {
 		body["media"] = map[string]any{
 			"media_keys": mediaKeys,
 		}
-		return p.client.Posts.Create(ctx, xdk.Params{"body": body})
+		resp, err = withRetry(ctx, retryUnsent, create)
+		if err == nil {
+			metricPostsCreated.inc(p.account, p.authMode)
+		}
+		return resp, err
 	}
 
 	return nil, err
 }
 
 func (p *Poster) GetTimeline(ctx context.Context, params xdk.Params) (xdk.JSON, error) {
-	pager := p.client.Users.GetTimeline(params)
-	page, ok, err := pager.Next(ctx)
-	if err != nil {
-		return nil, err
-	}
-	if !ok {
-		return xdk.JSON{"data": []any{}, "meta": map[string]any{"result_count": 0}}, nil
-	}
-	return page, nil
+	return withRetry(ctx, retrySafe, func(ctx context.Context) (xdk.JSON, error) {
+		pager := p.client.Users.GetTimeline(params)
+		page, ok, err := pager.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return xdk.JSON{"data": []any{}, "meta": map[string]any{"result_count": 0}}, nil
+		}
+		return page, nil
+	})
 }
 
 func mediaIDs(items []MediaRef) []string {
@@ -930,14 +1348,21 @@ func extractMediaRef(payload any) MediaRef {
 	// Keep stable priority: media_id_string > media_id > id.
 	id := findFirstByPriority(payload, []string{"media_id_string", "media_id", "id"})
 	key := findFirstByPriority(payload, []string{"media_key"})
-	return MediaRef{
+	ref := MediaRef{
 		ID:       id,
 		MediaKey: key,
 	}
+	if secs, err := strconv.ParseInt(findFirstByPriority(payload, []string{"expires_after_secs"}), 10, 64); err == nil && secs > 0 {
+		expires := time.Now().UTC().Add(time.Duration(secs) * time.Second)
+		ref.ExpiresAt = &expires
+	}
+	return ref
 }
 
 func findFirstByPriority(payload any, keys []string) string {
 	switch v := payload.(type) {
+	case xdk.JSON:
+		return findFirstByPriority(map[string]any(v), keys)
 	case map[string]any:
 		// Check current level by key priority first.
 		for _, key := range keys {
@@ -8,7 +8,6 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"os/exec"
 	"os/user"
@@ -33,6 +32,20 @@ func RunCLI(args []string) error {
 		return runLoginCommand(args[1:])
 	case "tweet":
 		return runTweetCommand(args[1:])
+	case "thread":
+		return runThreadCommand(args[1:])
+	case "delete":
+		return runDeleteCommand(args[1:])
+	case "media":
+		return runMediaCommand(args[1:])
+	case "check":
+		return runCheckCommand(args[1:])
+	case "outbox":
+		return runOutboxCommand(args[1:])
+	case "token":
+		return runTokenCommand(args[1:])
+	case "config":
+		return runConfigCommand(args[1:])
 	case "install":
 		return runInstallCommand(args[1:])
 	case "help", "-h", "--help":
@@ -47,8 +60,24 @@ func RunCLI(args []string) error {
 func printUsage() {
 	fmt.Println(`xpost commands:
   xpost serve
-  xpost login [--client-id ... --redirect-uri ... --scope tweet.read,tweet.write,users.read,offline.access]
-  xpost tweet --text "hello" [--media ./image.jpg]
+  xpost login [--client-id ... --redirect-uri ... --scope tweet.read,tweet.write,users.read,offline.access] [--manual] [--account NAME]
+  xpost tweet --text "hello" [--media ./image.jpg] [--at 2026-01-02T15:04:05Z] [--account NAME]
+              [--auto-thread --auto-thread-numbering]
+              [--reply-to ID --exclude-reply-users ID,ID] [--quote ID] [--reply-settings following|mentionedUsers] [--super-followers]
+              [--poll-option A --poll-option B --poll-duration 1440]
+  xpost thread --text "first" [--media ./a.jpg] --text "second" [--reply-to ID] [--account NAME]
+  xpost delete [--account NAME] ID [ID...]
+  xpost delete --last N [--account NAME --dry-run]
+  xpost media upload [--alt TEXT --account NAME] FILE
+  xpost check --text "hello"
+  xpost outbox list
+  xpost outbox retry ID
+  xpost outbox drop ID
+  xpost token create --scope tweets:write,media:write [--label ci --account NAME --expires 30d]
+  xpost token list
+  xpost token revoke ID
+  xpost config encrypt [--key-file PATH | --keyring]
+  xpost config decrypt
   xpost install [--bin /path/to/xpost --user nobody --dry-run]
 
 if no command is specified, xpost starts HTTP server mode (same as "xpost serve").`)
@@ -61,6 +90,8 @@ func runLoginCommand(args []string) error {
 	redirectURI := fs.String("redirect-uri", "", "OAuth2 redirect URI (or X_OAUTH2_REDIRECT_URI)")
 	scopeCSV := fs.String("scope", "", "OAuth2 scopes, comma-separated")
 	noOpen := fs.Bool("no-open", false, "Do not auto-open browser")
+	manual := fs.Bool("manual", false, "Paste the callback URL instead of running a local callback listener")
+	account := fs.String("account", "", "Named account profile to store the token under (default: top-level profile)")
 	if err := fs.Parse(args); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			return nil
@@ -72,42 +103,54 @@ func runLoginCommand(args []string) error {
 	if err != nil {
 		return err
 	}
+	auth, accountName := loginAccountAuth(cfg, *account)
 
 	if strings.TrimSpace(*clientID) != "" {
-		cfg.X.OAuth2ClientID = strings.TrimSpace(*clientID)
+		auth.OAuth2ClientID = strings.TrimSpace(*clientID)
 	}
 	if strings.TrimSpace(*clientSecret) != "" {
-		cfg.X.OAuth2ClientSecret = strings.TrimSpace(*clientSecret)
+		auth.OAuth2ClientSecret = strings.TrimSpace(*clientSecret)
 	}
 	if strings.TrimSpace(*redirectURI) != "" {
-		cfg.X.OAuth2RedirectURI = strings.TrimSpace(*redirectURI)
+		auth.OAuth2RedirectURI = strings.TrimSpace(*redirectURI)
 	}
 	if strings.TrimSpace(*scopeCSV) != "" {
-		cfg.X.OAuth2Scope = splitCSV(*scopeCSV)
+		auth.OAuth2Scope = splitCSV(*scopeCSV)
 	}
 
-	if strings.TrimSpace(cfg.X.OAuth2ClientID) == "" {
+	if strings.TrimSpace(auth.OAuth2ClientID) == "" {
 		return errors.New("oauth2 client id is required (set --client-id or X_OAUTH2_CLIENT_ID)")
 	}
-	if strings.TrimSpace(cfg.X.OAuth2RedirectURI) == "" {
-		cfg.X.OAuth2RedirectURI = defaultRedirectURI
+	if strings.TrimSpace(auth.OAuth2RedirectURI) == "" {
+		auth.OAuth2RedirectURI = defaultRedirectURI
 		fmt.Printf("Using default redirect URI: %s\n", defaultRedirectURI)
 		fmt.Println("Make sure this URI is added to your app's callback URLs in the X Developer Portal.")
 	}
 
-	scopes := effectiveOAuth2Scopes(cfg.X.OAuth2Scope)
+	scopes := effectiveOAuth2Scopes(auth.OAuth2Scope)
 	client := xdk.NewClient(xdk.Config{
-		ClientID:     cfg.X.OAuth2ClientID,
-		ClientSecret: cfg.X.OAuth2ClientSecret,
-		RedirectURI:  cfg.X.OAuth2RedirectURI,
+		ClientID:     auth.OAuth2ClientID,
+		ClientSecret: auth.OAuth2ClientSecret,
+		RedirectURI:  auth.OAuth2RedirectURI,
 		Scope:        scopes,
 	})
 
-	authURL, err := client.GetAuthorizationURL(generateToken())
+	state := generateToken()
+	authURL, err := client.GetAuthorizationURL(state)
 	if err != nil {
 		return fmt.Errorf("failed to generate authorization URL: %w", err)
 	}
 
+	// Start listening before the browser opens so a fast redirect is not missed.
+	var callback *oauthCallbackListener
+	if !*manual {
+		callback, err = listenForOAuthCallback(auth.OAuth2RedirectURI, state)
+		if err != nil {
+			return fmt.Errorf("%w (use --manual to paste the callback URL instead)", err)
+		}
+		defer callback.Close()
+	}
+
 	fmt.Printf("Open this URL to authorize:\n%s\n\n", authURL)
 	if !*noOpen {
 		if err := openBrowser(authURL); err != nil {
@@ -115,15 +158,29 @@ func runLoginCommand(args []string) error {
 		}
 	}
 
-	fmt.Print("Paste callback URL: ")
-	reader := bufio.NewReader(os.Stdin)
-	callbackURL, err := reader.ReadString('\n')
-	if err != nil && !errors.Is(err, io.EOF) {
-		return err
-	}
-	callbackURL = strings.TrimSpace(callbackURL)
-	if callbackURL == "" {
-		return errors.New("callback URL cannot be empty")
+	var callbackURL string
+	if callback != nil {
+		fmt.Printf("Waiting for the authorization callback on %s ...\n", auth.OAuth2RedirectURI)
+		waitCtx, waitCancel := context.WithTimeout(context.Background(), oauthCallbackTimeout)
+		callbackURL, err = callback.Wait(waitCtx)
+		waitCancel()
+		if err != nil {
+			return err
+		}
+	} else {
+		fmt.Print("Paste callback URL: ")
+		reader := bufio.NewReader(os.Stdin)
+		callbackURL, err = reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		callbackURL = strings.TrimSpace(callbackURL)
+		if callbackURL == "" {
+			return errors.New("callback URL cannot be empty")
+		}
+		if err := checkOAuthCallbackState(callbackURL, state); err != nil {
+			return err
+		}
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
@@ -134,23 +191,38 @@ func runLoginCommand(args []string) error {
 		return fmt.Errorf("oauth2 token exchange failed: %w", err)
 	}
 
-	cfg.X.OAuth2Scope = scopes
-	if err := applyOAuth2TokenToConfig(&cfg.X, token); err != nil {
+	auth.OAuth2Scope = scopes
+	if err := applyOAuth2TokenToConfig(auth, token); err != nil {
 		return err
 	}
 	if err := saveConfig(configPath, cfg); err != nil {
 		return fmt.Errorf("failed to save config: %w", err)
 	}
 
-	fmt.Printf("Login succeeded. OAuth2 token saved to %s\n", configPath)
+	fmt.Printf("Login succeeded. OAuth2 token for account %s saved to %s\n", accountName, configPath)
 	return nil
 }
 
 func runTweetCommand(args []string) error {
 	fs := flag.NewFlagSet("tweet", flag.ContinueOnError)
 	text := fs.String("text", "", "Tweet text")
-	var mediaFiles stringSliceFlag
+	var mediaFiles mediaPathsFlag
 	fs.Var(&mediaFiles, "media", "Media file path (repeatable, max 4)")
+	fs.Var(altTextFlag{&mediaFiles}, "alt", "Alt text for the most recent --media")
+	var mediaIDFlags stringSliceFlag
+	fs.Var(&mediaIDFlags, "media-id", "ID of media uploaded earlier, e.g. with `xpost media upload` (repeatable)")
+	at := fs.String("at", "", "Schedule the post for this time (RFC 3339 or unix seconds) instead of posting now")
+	account := fs.String("account", "", "Named account profile to post as")
+	replyTo := fs.String("reply-to", "", "Tweet ID to reply to")
+	quote := fs.String("quote", "", "Tweet ID to quote")
+	replySettings := fs.String("reply-settings", "", "Who can reply: following or mentionedUsers (default: everyone)")
+	excludeReplyUsers := fs.String("exclude-reply-users", "", "Comma-separated user IDs to leave out of the reply's mentions (needs --reply-to)")
+	superFollowers := fs.Bool("super-followers", false, "Only show the post to super followers")
+	var pollOptions stringSliceFlag
+	fs.Var(&pollOptions, "poll-option", "Poll option (repeat 2-4 times)")
+	pollDuration := fs.Int("poll-duration", 1440, "Poll duration in minutes (5 to 10080)")
+	autoThread := fs.Bool("auto-thread", false, "Split text that is too long into a thread instead of failing")
+	autoThreadNumbering := fs.Bool("auto-thread-numbering", false, "Add \" i/n\" to each post of an --auto-thread split")
 	if err := fs.Parse(args); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			return nil
@@ -158,52 +230,131 @@ func runTweetCommand(args []string) error {
 		return err
 	}
 
+	opts := tweetOptions{
+		QuoteTweetID:          *quote,
+		ReplySettings:         *replySettings,
+		ExcludeReplyUserIDs:   splitCSV(*excludeReplyUsers),
+		ForSuperFollowersOnly: *superFollowers,
+	}
+	if len(pollOptions) > 0 {
+		opts.Poll = &tweetPoll{Options: pollOptions, DurationMinutes: *pollDuration}
+	}
+	mediaIDs, err := parseMediaIDs(mediaIDFlags)
+	if err != nil {
+		return err
+	}
+	if len(mediaFiles)+len(mediaIDs) > maxMediaCount {
+		return fmt.Errorf("too many media items, max is %d", maxMediaCount)
+	}
+	replyToTweetID := strings.TrimSpace(*replyTo)
+	if err := opts.normalize(replyToTweetID, len(mediaFiles)+len(mediaIDs)); err != nil {
+		return err
+	}
+
 	tweetText := strings.TrimSpace(*text)
 	if tweetText == "" && fs.NArg() > 0 {
 		tweetText = strings.TrimSpace(strings.Join(fs.Args(), " "))
 	}
+	threadParts, err := autoThreadText(tweetText, *autoThread, *autoThreadNumbering)
+	if err != nil {
+		return err
+	}
 
 	mediaInputs, err := mediaInputsFromPaths(mediaFiles)
 	if err != nil {
 		return err
 	}
-	if tweetText == "" && len(mediaInputs) == 0 {
+	if tweetText == "" && len(mediaInputs) == 0 && len(mediaIDs) == 0 {
 		return errors.New("text or media is required")
 	}
 
+	publishAt, err := parsePublishAt(*at)
+	if err != nil {
+		return err
+	}
+	if len(threadParts) > 0 && !publishAt.IsZero() {
+		return errors.New("--auto-thread cannot be combined with --at")
+	}
+
 	cfg, configPath, err := loadCLIConfig()
 	if err != nil {
 		return err
 	}
 
-	poster, err := newPoster(cfg.X)
+	defer func() { releaseMedia(mediaInputs) }()
+	if err := prepareMedia(mediaInputs, cfg.Images); err != nil {
+		return err
+	}
+
+	if !publishAt.IsZero() {
+		_, accountName, err := cfg.accountAuth(*account)
+		if err != nil {
+			return err
+		}
+		if err := newMediaLibrary(filepath.Dir(configPath)).checkMediaIDsUsable(mediaIDs, publishAt); err != nil {
+			return err
+		}
+		store := newScheduleStore(filepath.Dir(configPath))
+		post, err := store.add(tweetRequest{
+			Text:           tweetText,
+			Media:          mediaInputs,
+			MediaIDs:       mediaIDs,
+			ReplyToTweetID: replyToTweetID,
+			Account:        accountName,
+			PublishAt:      publishAt,
+			tweetOptions:   opts,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to schedule post: %w", err)
+		}
+		b, err := json.MarshalIndent(map[string]any{"ok": true, "scheduled": post}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	poster, err := newAccountPoster(cfg, *account)
 	if err != nil {
 		return fmt.Errorf("x auth is not ready: %w (run `xpost login` for oauth2)", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout(mediaInputs))
 	defer cancel()
 
-	uploaded := make([]MediaRef, 0, len(mediaInputs))
-	for _, input := range mediaInputs {
-		ref, err := poster.UploadMedia(ctx, input.Data, input.ContentType)
-		if err != nil {
-			return err
+	if len(threadParts) > 0 {
+		items := autoThreadItems(tweetRequest{
+			Media:        mediaInputs,
+			MediaIDs:     mediaIDs,
+			tweetOptions: opts,
+			threadParts:  threadParts,
+		})
+		result, postErr := postThread(ctx, poster, newMediaLibrary(filepath.Dir(configPath)), items, replyToTweetID)
+		if err := persistOAuth2TokenIfAvailable(cfg, configPath, poster.account, poster.client); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to persist refreshed oauth2 token: %v\n", err)
 		}
-		uploaded = append(uploaded, ref)
+		return printThreadResult(poster, result, postErr)
 	}
 
-	tweetResp, err := poster.CreateTweet(ctx, tweetText, uploaded, "")
+	uploaded, err := uploadMediaInputs(ctx, poster, newMediaLibrary(filepath.Dir(configPath)), mediaInputs)
 	if err != nil {
 		return err
 	}
+	uploaded = append(uploaded, mediaRefsFromIDs(mediaIDs)...)
 
-	if err := persistOAuth2TokenIfAvailable(cfg, configPath, poster.client); err != nil {
+	tweetResp, err := poster.CreateTweet(ctx, tweetText, uploaded, replyToTweetID, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := persistOAuth2TokenIfAvailable(cfg, configPath, poster.account, poster.client); err != nil {
 		fmt.Fprintf(os.Stderr, "warning: failed to persist refreshed oauth2 token: %v\n", err)
 	}
 
 	out := map[string]any{
 		"ok":          true,
+		"account":     poster.account,
 		"auth_mode":   poster.authMode,
 		"media_count": len(uploaded),
 		"media":       uploaded,
@@ -217,6 +368,527 @@ func runTweetCommand(args []string) error {
 	return nil
 }
 
+func runThreadCommand(args []string) error {
+	fs := flag.NewFlagSet("thread", flag.ContinueOnError)
+	var items threadItemsFlag
+	fs.Var(threadTextFlag{&items}, "text", "Post text, starts a new post in the thread (repeatable)")
+	fs.Var(threadMediaFlag{&items}, "media", "Media file path for the current post (repeatable, max 4 per post)")
+	fs.Var(threadAltFlag{&items}, "alt", "Alt text for the most recent --media")
+	replyTo := fs.String("reply-to", "", "Tweet ID the first post replies to")
+	account := fs.String("account", "", "Named account profile to post as")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if len(items) == 0 {
+		return errors.New("at least one --text is required")
+	}
+	if len(items) > maxThreadItems {
+		return fmt.Errorf("too many thread items, max is %d", maxThreadItems)
+	}
+
+	threadItems := make([]threadItem, 0, len(items))
+	for i, item := range items {
+		if err := checkTweetText(item.Text); err != nil {
+			return fmt.Errorf("post %d: %w", i+1, err)
+		}
+		media, err := mediaInputsFromPaths(item.Media)
+		if err != nil {
+			return fmt.Errorf("post %d: %w", i+1, err)
+		}
+		if item.Text == "" && len(media) == 0 {
+			return fmt.Errorf("post %d: text or media is required", i+1)
+		}
+		threadItems = append(threadItems, threadItem{Text: item.Text, Media: media})
+	}
+
+	cfg, configPath, err := loadCLIConfig()
+	if err != nil {
+		return err
+	}
+
+	defer releaseThreadMedia(threadItems)
+	for i, item := range threadItems {
+		if err := prepareMedia(item.Media, cfg.Images); err != nil {
+			return fmt.Errorf("post %d: %w", i+1, err)
+		}
+	}
+
+	poster, err := newAccountPoster(cfg, *account)
+	if err != nil {
+		return fmt.Errorf("x auth is not ready: %w (run `xpost login` for oauth2)", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), threadTimeout(threadItems))
+	defer cancel()
+
+	result, postErr := postThread(ctx, poster, newMediaLibrary(filepath.Dir(configPath)), threadItems, *replyTo)
+
+	if err := persistOAuth2TokenIfAvailable(cfg, configPath, poster.account, poster.client); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to persist refreshed oauth2 token: %v\n", err)
+	}
+	return printThreadResult(poster, result, postErr)
+}
+
+func printThreadResult(poster *Poster, result threadResult, postErr error) error {
+	out := map[string]any{
+		"ok":         postErr == nil,
+		"account":    poster.account,
+		"auth_mode":  poster.authMode,
+		"tweet_ids":  result.TweetIDs,
+		"tweets":     result.Tweets,
+		"post_count": len(result.TweetIDs),
+	}
+	if postErr != nil {
+		out["error"] = postErr.Error()
+		var te *threadError
+		postedWithoutID := errors.As(postErr, &te) && te.postedWithoutID()
+		if postedWithoutID {
+			out["failed_index"] = te.Index
+			out["posted_without_id"] = true
+		}
+		if n := len(result.TweetIDs); n > 0 && !postedWithoutID {
+			out["resume_reply_to_tweet_id"] = result.TweetIDs[n-1]
+		}
+	}
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return postErr
+}
+
+func runDeleteCommand(args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	last := fs.Int("last", 0, fmt.Sprintf("Delete the N most recent posts of the account (max %d)", maxDeleteLast))
+	account := fs.String("account", "", "Named account profile to delete as")
+	dryRun := fs.Bool("dry-run", false, "Print the posts --last would delete without deleting them")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	ids := uniqueNonEmpty(fs.Args())
+	switch {
+	case *last < 0 || *last > maxDeleteLast:
+		return fmt.Errorf("--last must be between 1 and %d", maxDeleteLast)
+	case *last > 0 && len(ids) > 0:
+		return errors.New("pass either tweet IDs or --last, not both")
+	case *last == 0 && len(ids) == 0:
+		return errors.New("tweet id or --last is required")
+	}
+
+	cfg, configPath, err := loadCLIConfig()
+	if err != nil {
+		return err
+	}
+
+	poster, err := newAccountPoster(cfg, *account)
+	if err != nil {
+		return fmt.Errorf("x auth is not ready: %w (run `xpost login` for oauth2)", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+	defer cancel()
+
+	var deleted []string
+	var deleteErr error
+	if *last > 0 {
+		auth, _, err := cfg.accountAuth(poster.account)
+		if err != nil {
+			return err
+		}
+		userID := strings.TrimSpace(auth.UserID)
+		if userID == "" {
+			return fmt.Errorf("user_id is not configured for account %s (set X_USER_ID or run `xpost login`)", poster.account)
+		}
+		ids, deleteErr = poster.RecentTweetIDs(ctx, userID, *last)
+	}
+	if deleteErr == nil && !*dryRun {
+		deleted, deleteErr = deleteTweets(ctx, poster, ids)
+	}
+
+	if err := persistOAuth2TokenIfAvailable(cfg, configPath, poster.account, poster.client); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to persist refreshed oauth2 token: %v\n", err)
+	}
+
+	out := map[string]any{
+		"ok":        deleteErr == nil,
+		"account":   poster.account,
+		"auth_mode": poster.authMode,
+	}
+	if *dryRun {
+		out["would_delete"] = ids
+	} else {
+		out["deleted"] = deleted
+		out["count"] = len(deleted)
+	}
+	if deleteErr != nil {
+		out["error"] = deleteErr.Error()
+	}
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return deleteErr
+}
+
+func runMediaCommand(args []string) error {
+	if len(args) == 0 {
+		printUsage()
+		return errors.New("media subcommand is required (upload)")
+	}
+
+	switch args[0] {
+	case "upload":
+		return runMediaUploadCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown media subcommand: %s", args[0])
+	}
+}
+
+func runMediaUploadCommand(args []string) error {
+	fs := flag.NewFlagSet("media upload", flag.ContinueOnError)
+	alt := fs.String("alt", "", "Alt text for the file")
+	account := fs.String("account", "", "Named account profile to upload as")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("exactly one media file is required")
+	}
+
+	media, err := mediaInputsFromPaths([]mediaPath{{Path: fs.Arg(0), AltText: *alt}})
+	if err != nil {
+		return err
+	}
+
+	cfg, configPath, err := loadCLIConfig()
+	if err != nil {
+		return err
+	}
+	defer func() { releaseMedia(media) }()
+	if err := prepareMedia(media, cfg.Images); err != nil {
+		return err
+	}
+
+	poster, err := newAccountPoster(cfg, *account)
+	if err != nil {
+		return fmt.Errorf("x auth is not ready: %w (run `xpost login` for oauth2)", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout(media))
+	defer cancel()
+
+	uploaded, uploadErr := uploadMediaInputs(ctx, poster, newMediaLibrary(filepath.Dir(configPath)), media)
+	if err := persistOAuth2TokenIfAvailable(cfg, configPath, poster.account, poster.client); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to persist refreshed oauth2 token: %v\n", err)
+	}
+	if uploadErr != nil {
+		return uploadErr
+	}
+
+	b, err := json.MarshalIndent(map[string]any{
+		"ok":        true,
+		"account":   poster.account,
+		"auth_mode": poster.authMode,
+		"media":     uploaded[0],
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+func runCheckCommand(args []string) error {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	text := fs.String("text", "", "Text to check (default: remaining arguments, or stdin)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	checkText := *text
+	if checkText == "" && fs.NArg() > 0 {
+		checkText = strings.Join(fs.Args(), " ")
+	}
+	if checkText == "" {
+		b, err := io.ReadAll(io.LimitReader(os.Stdin, maxFormValueBytes))
+		if err != nil {
+			return err
+		}
+		checkText = string(b)
+	}
+
+	count := countTweetText(strings.TrimSpace(checkText))
+	b, err := json.MarshalIndent(count, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	if !count.Valid {
+		return errors.New(count.Error)
+	}
+	return nil
+}
+
+func runOutboxCommand(args []string) error {
+	if len(args) == 0 {
+		printUsage()
+		return errors.New("outbox subcommand is required (list, retry, drop)")
+	}
+
+	switch args[0] {
+	case "list":
+		return runOutboxListCommand()
+	case "retry":
+		return runOutboxRetryCommand(args[1:])
+	case "drop":
+		return runOutboxDropCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown outbox subcommand: %s", args[0])
+	}
+}
+
+func cliOutboxStore() (*outboxStore, error) {
+	_, configPath, err := loadCLIConfig()
+	if err != nil {
+		return nil, err
+	}
+	return newOutboxStore(filepath.Dir(configPath)), nil
+}
+
+func runOutboxListCommand() error {
+	store, err := cliOutboxStore()
+	if err != nil {
+		return err
+	}
+	doc, err := store.list()
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(map[string]any{
+		"outbox":      doc.Entries,
+		"dead_letter": doc.DeadLetter,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+func runOutboxRetryCommand(args []string) error {
+	if len(args) != 1 || strings.TrimSpace(args[0]) == "" {
+		return errors.New("usage: xpost outbox retry ID")
+	}
+	id := strings.TrimSpace(args[0])
+
+	store, err := cliOutboxStore()
+	if err != nil {
+		return err
+	}
+	if _, err := store.retry(id); err != nil {
+		return err
+	}
+	fmt.Printf("Queued outbox entry %s for delivery\n", id)
+	return nil
+}
+
+func runOutboxDropCommand(args []string) error {
+	if len(args) != 1 || strings.TrimSpace(args[0]) == "" {
+		return errors.New("usage: xpost outbox drop ID")
+	}
+	id := strings.TrimSpace(args[0])
+
+	store, err := cliOutboxStore()
+	if err != nil {
+		return err
+	}
+	if err := store.drop(id); err != nil {
+		return err
+	}
+	fmt.Printf("Dropped outbox entry %s\n", id)
+	return nil
+}
+
+func runTokenCommand(args []string) error {
+	if len(args) == 0 {
+		printUsage()
+		return errors.New("token subcommand is required (create, list, revoke)")
+	}
+
+	switch args[0] {
+	case "create":
+		return runTokenCreateCommand(args[1:])
+	case "list":
+		return runTokenListCommand()
+	case "revoke":
+		return runTokenRevokeCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown token subcommand: %s", args[0])
+	}
+}
+
+func runTokenCreateCommand(args []string) error {
+	fs := flag.NewFlagSet("token create", flag.ContinueOnError)
+	label := fs.String("label", "", "Human-readable label, e.g. the CI job or bot name")
+	scopeCSV := fs.String("scope", "", "Comma-separated scopes: tweets:write, timeline:read, media:write, metrics:read, or * for all")
+	accountCSV := fs.String("account", "", "Comma-separated accounts the token may use (default: all)")
+	expires := fs.String("expires", "", "Lifetime such as 720h or 30d, or an RFC 3339 expiry time (default: never)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	scopes, err := normalizeScopes(splitCSV(*scopeCSV))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	expiresAt, err := parseTokenExpiry(*expires, now)
+	if err != nil {
+		return err
+	}
+
+	cfg, configPath, err := loadCLIConfig()
+	if err != nil {
+		return err
+	}
+
+	accounts := splitCSV(*accountCSV)
+	for _, name := range accounts {
+		if _, _, err := cfg.accountAuth(name); err != nil {
+			return err
+		}
+	}
+
+	plain, token, err := newHashedAPIToken(newRecordID(), strings.TrimSpace(*label), scopes)
+	if err != nil {
+		return err
+	}
+	token.Accounts = accounts
+	token.ExpiresAt = expiresAt
+	// cfg carries env overrides and only vets the account names; the token
+	// goes into the stored config so X_* and XPOST_* values stay off disk.
+	err = updateConfigFile(configPath, func(disk *Config) error {
+		disk.Security.APITokens = append(disk.Security.APITokens, token)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	out := map[string]any{
+		"id":     token.ID,
+		"label":  token.Label,
+		"token":  plain,
+		"scopes": token.Scopes,
+		"note":   "store this token now, it cannot be shown again",
+	}
+	if len(accounts) > 0 {
+		out["accounts"] = accounts
+	}
+	if expiresAt > 0 {
+		out["expires_at"] = time.Unix(expiresAt, 0).UTC().Format(time.RFC3339)
+	}
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+func runTokenListCommand() error {
+	cfg, _, err := loadCLIConfig()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	out := make([]map[string]any, 0, len(cfg.Security.APITokens)+1)
+	for _, token := range cfg.Security.allTokens() {
+		item := map[string]any{
+			"id":      token.ID,
+			"label":   token.Label,
+			"scopes":  token.Scopes,
+			"expired": token.expired(now),
+		}
+		if len(token.Accounts) > 0 {
+			item["accounts"] = token.Accounts
+		}
+		if token.CreatedAt > 0 {
+			item["created_at"] = time.Unix(token.CreatedAt, 0).UTC().Format(time.RFC3339)
+		}
+		if token.ExpiresAt > 0 {
+			item["expires_at"] = time.Unix(token.ExpiresAt, 0).UTC().Format(time.RFC3339)
+		}
+		out = append(out, item)
+	}
+
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+func runTokenRevokeCommand(args []string) error {
+	if len(args) != 1 || strings.TrimSpace(args[0]) == "" {
+		return errors.New("usage: xpost token revoke ID")
+	}
+	id := strings.TrimSpace(args[0])
+
+	_, configPath, err := loadStoredCLIConfig()
+	if err != nil {
+		return err
+	}
+
+	errNotFound := fmt.Errorf("api token %q not found", id)
+	err = updateConfigFile(configPath, func(disk *Config) error {
+		found := false
+		kept := disk.Security.APITokens[:0]
+		for _, token := range disk.Security.APITokens {
+			if token.ID == id {
+				found = true
+				continue
+			}
+			kept = append(kept, token)
+		}
+		disk.Security.APITokens = kept
+		if !found {
+			return errNotFound
+		}
+		return nil
+	})
+	if errors.Is(err, errNotFound) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	fmt.Printf("Revoked api token %s\n", id)
+	return nil
+}
+
 func runInstallCommand(args []string) error {
 	fs := flag.NewFlagSet("install", flag.ContinueOnError)
 	binPath := fs.String("bin", "", "xpost binary path (default: current executable)")
@@ -240,7 +912,7 @@ func runInstallCommand(args []string) error {
 	if err != nil {
 		return err
 	}
-	if err := ensureFirstBootAuthConfigured(cfg.X); err != nil {
+	if err := ensureAnyAccountConfigured(cfg); err != nil {
 		return fmt.Errorf("credentials not configured: %w\nrun `xpost login` first", err)
 	}
 
@@ -300,7 +972,103 @@ func runInstallCommand(args []string) error {
 	return nil
 }
 
+func runConfigCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("config subcommand is required (encrypt, decrypt)")
+	}
+
+	switch args[0] {
+	case "encrypt":
+		return runConfigEncryptCommand(args[1:])
+	case "decrypt":
+		return runConfigDecryptCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown config subcommand: %s", args[0])
+	}
+}
+
+// runConfigEncryptCommand seals the X credentials in place. Running it on an
+// encrypted file with a different key re-encrypts under the new key. The
+// config is loaded without env overrides so X_* secrets never reach disk.
+func runConfigEncryptCommand(args []string) error {
+	fs := flag.NewFlagSet("config encrypt", flag.ContinueOnError)
+	keyFile := fs.String("key-file", "", "Master key file, created with a random key if missing")
+	keyring := fs.Bool("keyring", false, "Keep the master key in the OS keyring (macOS keychain or Secret Service)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	cfg, configPath, err := loadStoredCLIConfig()
+	if err != nil {
+		return err
+	}
+
+	secrets := SecretsConfig{}
+	if cfg.Secrets != nil {
+		secrets = *cfg.Secrets
+	}
+	if strings.TrimSpace(*keyFile) != "" {
+		path, err := filepath.Abs(strings.TrimSpace(*keyFile))
+		if err != nil {
+			return err
+		}
+		secrets.KeyFile = path
+		secrets.Keyring = false
+	}
+	if *keyring {
+		secrets.Keyring = true
+		secrets.KeyFile = ""
+	}
+
+	key, source, err := loadOrCreateMasterKey(&secrets)
+	if err != nil {
+		return err
+	}
+	secrets.Encrypted = true
+	cfg.Secrets = &secrets
+	cfg.masterKey = key
+	if err := saveConfig(configPath, cfg); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	fmt.Printf("Encrypted X credentials in %s (master key: %s)\n", configPath, source)
+	return nil
+}
+
+func runConfigDecryptCommand(args []string) error {
+	fs := flag.NewFlagSet("config decrypt", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	cfg, configPath, err := loadStoredCLIConfig()
+	if err != nil {
+		return err
+	}
+	cfg.Secrets = nil
+	if err := saveConfig(configPath, cfg); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	fmt.Printf("Decrypted X credentials in %s\n", configPath)
+	return nil
+}
+
 func loadCLIConfig() (*Config, string, error) {
+	cfg, configPath, err := loadStoredCLIConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	overrideConfigFromEnv(cfg)
+	return cfg, configPath, nil
+}
+
+// loadStoredCLIConfig loads the config file without env overrides.
+func loadStoredCLIConfig() (*Config, string, error) {
 	configPath := os.Getenv("XPOST_CONFIG")
 	if strings.TrimSpace(configPath) == "" {
 		configPath = defaultConfigPath()
@@ -310,11 +1078,11 @@ func loadCLIConfig() (*Config, string, error) {
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to load config: %w", err)
 	}
-	overrideConfigFromEnv(cfg)
+	announceGeneratedToken(os.Stderr, cfg.Security.generatedToken, configPath)
 	return cfg, configPath, nil
 }
 
-func persistOAuth2TokenIfAvailable(cfg *Config, configPath string, client *xdk.Client) error {
+func persistOAuth2TokenIfAvailable(cfg *Config, configPath string, account string, client *xdk.Client) error {
 	if cfg == nil || client == nil || client.OAuth2Auth == nil {
 		return nil
 	}
@@ -322,10 +1090,46 @@ func persistOAuth2TokenIfAvailable(cfg *Config, configPath string, client *xdk.C
 	if len(token) == 0 {
 		return nil
 	}
-	if err := applyOAuth2TokenToConfig(&cfg.X, token); err != nil {
+	auth, _, err := cfg.accountAuth(account)
+	if err != nil {
+		return err
+	}
+	if err := applyOAuth2TokenToConfig(auth, token); err != nil {
 		return nil
 	}
-	return saveConfig(configPath, cfg)
+	// Only this account's token goes to disk, so accounts, api tokens and
+	// settings another process saved in the meantime are kept.
+	return updateConfigFile(configPath, func(disk *Config) error {
+		auth, _, err := disk.accountAuth(account)
+		if err != nil {
+			return err
+		}
+		return applyOAuth2TokenToConfig(auth, token)
+	})
+}
+
+// loginAccountAuth returns the profile `xpost login` writes to, creating a
+// named profile on first use. A new profile inherits the OAuth2 app settings
+// of the top-level profile, since several accounts usually share one app.
+func loginAccountAuth(cfg *Config, account string) (*XAuthConfig, string) {
+	name := strings.TrimSpace(account)
+	if name == "" || name == defaultAccountName {
+		return &cfg.X, defaultAccountName
+	}
+	if cfg.Accounts == nil {
+		cfg.Accounts = make(map[string]*XAuthConfig)
+	}
+	auth := cfg.Accounts[name]
+	if auth == nil {
+		auth = &XAuthConfig{
+			OAuth2ClientID:     cfg.X.OAuth2ClientID,
+			OAuth2ClientSecret: cfg.X.OAuth2ClientSecret,
+			OAuth2RedirectURI:  cfg.X.OAuth2RedirectURI,
+			OAuth2Scope:        cfg.X.OAuth2Scope,
+		}
+		cfg.Accounts[name] = auth
+	}
+	return auth, name
 }
 
 func applyOAuth2TokenToConfig(cfg *XAuthConfig, token map[string]any) error {
@@ -402,28 +1206,27 @@ func toInt64(v any) (int64, bool) {
 	}
 }
 
-func mediaInputsFromPaths(paths []string) ([]mediaUploadInput, error) {
+func mediaInputsFromPaths(paths []mediaPath) ([]mediaUploadInput, error) {
 	if len(paths) > maxMediaCount {
 		return nil, fmt.Errorf("too many media files, max is %d", maxMediaCount)
 	}
 
 	media := make([]mediaUploadInput, 0, len(paths))
+	altTexts := make([]string, 0, len(paths))
 	for _, p := range paths {
-		path := filepath.Clean(strings.TrimSpace(p))
+		path := filepath.Clean(strings.TrimSpace(p.Path))
 		if path == "" {
 			continue
 		}
-		data, err := os.ReadFile(path)
+		input, err := mediaInputFromFile(path)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read media file %q: %w", path, err)
-		}
-		if int64(len(data)) > maxMediaBytes {
-			return nil, fmt.Errorf("file %q exceeds max size %d bytes", path, maxMediaBytes)
+			return nil, err
 		}
-		media = append(media, mediaUploadInput{
-			Data:        data,
-			ContentType: http.DetectContentType(data),
-		})
+		media = append(media, input)
+		altTexts = append(altTexts, p.AltText)
+	}
+	if err := setAltTexts(media, altTexts); err != nil {
+		return nil, err
 	}
 	return media, nil
 }
@@ -497,3 +1300,112 @@ func (s *stringSliceFlag) Set(value string) error {
 	*s = append(*s, v)
 	return nil
 }
+
+// threadItemsFlag collects thread posts from ordered flags: each --text starts
+// a new post, each --media attaches to the most recent one and each --alt
+// describes the --media before it.
+type threadItemsFlag []threadItemPaths
+
+type threadItemPaths struct {
+	Text  string
+	Media mediaPathsFlag
+}
+
+type threadTextFlag struct {
+	items *threadItemsFlag
+}
+
+func (f threadTextFlag) String() string {
+	return ""
+}
+
+func (f threadTextFlag) Set(value string) error {
+	*f.items = append(*f.items, threadItemPaths{Text: strings.TrimSpace(value)})
+	return nil
+}
+
+type threadMediaFlag struct {
+	items *threadItemsFlag
+}
+
+func (f threadMediaFlag) String() string {
+	return ""
+}
+
+func (f threadMediaFlag) Set(value string) error {
+	v := strings.TrimSpace(value)
+	if v == "" {
+		return nil
+	}
+	if len(*f.items) == 0 {
+		*f.items = append(*f.items, threadItemPaths{})
+	}
+	last := &(*f.items)[len(*f.items)-1]
+	last.Media = append(last.Media, mediaPath{Path: v})
+	return nil
+}
+
+type threadAltFlag struct {
+	items *threadItemsFlag
+}
+
+func (f threadAltFlag) String() string {
+	return ""
+}
+
+func (f threadAltFlag) Set(value string) error {
+	if len(*f.items) == 0 {
+		return errors.New("--alt must follow a --media")
+	}
+	last := &(*f.items)[len(*f.items)-1]
+	return altTextFlag{&last.Media}.Set(value)
+}
+
+// mediaPathsFlag collects --media paths in order so that each --alt can
+// attach to the file just before it.
+type mediaPathsFlag []mediaPath
+
+type mediaPath struct {
+	Path    string
+	AltText string
+}
+
+func (m *mediaPathsFlag) String() string {
+	if m == nil {
+		return ""
+	}
+	paths := make([]string, 0, len(*m))
+	for _, p := range *m {
+		paths = append(paths, p.Path)
+	}
+	return strings.Join(paths, ",")
+}
+
+func (m *mediaPathsFlag) Set(value string) error {
+	v := strings.TrimSpace(value)
+	if v == "" {
+		return nil
+	}
+	*m = append(*m, mediaPath{Path: v})
+	return nil
+}
+
+type altTextFlag struct {
+	media *mediaPathsFlag
+}
+
+func (f altTextFlag) String() string {
+	return ""
+}
+
+func (f altTextFlag) Set(value string) error {
+	if len(*f.media) == 0 {
+		return errors.New("--alt must follow a --media")
+	}
+	last := &(*f.media)[len(*f.media)-1]
+	if last.AltText != "" {
+		return fmt.Errorf("%s already has alt text", last.Path)
+	}
+	last.AltText = strings.TrimSpace(value)
+	return nil
+}
@@ -0,0 +1,113 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	xdk "github.com/missuo/xdk-go"
+)
+
+// maxDeleteLast caps `xpost delete --last` so a typo cannot wipe an account.
+const maxDeleteLast = 100
+
+func (a *App) handleDeleteTweet(c *gin.Context) {
+	id := strings.TrimSpace(c.Param("id"))
+	if id == "" {
+		c.JSON(http.StatusBadRequest, errorBody(codeValidationFailed, "tweet id is required"))
+		return
+	}
+
+	poster, ok := a.posterForRequest(c, requestAccount(c, c.Query("account")))
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 90*time.Second)
+	defer cancel()
+
+	resp, err := poster.DeleteTweet(ctx, id)
+	a.persistOAuth2Token(poster)
+	if err != nil {
+		c.JSON(errorResponse(err, http.StatusBadGateway, codeXError))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":        true,
+		"account":   poster.account,
+		"auth_mode": poster.authMode,
+		"id":        id,
+		"deleted":   tweetDeleted(resp),
+		"response":  resp,
+	})
+}
+
+func (p *Poster) DeleteTweet(ctx context.Context, id string) (xdk.JSON, error) {
+	return p.client.Posts.Delete(ctx, xdk.Params{"id": strings.TrimSpace(id)})
+}
+
+// RecentTweetIDs returns up to n of the user's own most recent post IDs,
+// newest first. It reads the user's posts rather than the home timeline,
+// which also holds other accounts' posts.
+func (p *Poster) RecentTweetIDs(ctx context.Context, userID string, n int) ([]string, error) {
+	pageSize := n
+	if pageSize < 5 {
+		pageSize = 5
+	}
+	if pageSize > 100 {
+		pageSize = 100
+	}
+
+	ids := make([]string, 0, n)
+	pager := p.client.Users.GetPosts(xdk.Params{"id": userID, "max_results": pageSize})
+	for len(ids) < n {
+		page, ok, err := pager.Next(ctx)
+		if err != nil {
+			return ids, err
+		}
+		if !ok {
+			break
+		}
+		data, _ := page["data"].([]any)
+		if len(data) == 0 {
+			break
+		}
+		for _, item := range data {
+			post, _ := item.(map[string]any)
+			if id := stringify(post["id"]); id != "" && len(ids) < n {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+func tweetDeleted(resp xdk.JSON) bool {
+	data, ok := resp["data"].(map[string]any)
+	if !ok {
+		return false
+	}
+	deleted, _ := data["deleted"].(bool)
+	return deleted
+}
+
+// deleteTweets deletes ids in order and stops at the first failure, returning
+// the IDs removed so far.
+func deleteTweets(ctx context.Context, poster *Poster, ids []string) ([]string, error) {
+	deleted := make([]string, 0, len(ids))
+	for _, id := range ids {
+		resp, err := poster.DeleteTweet(ctx, id)
+		if err != nil {
+			return deleted, fmt.Errorf("delete %s failed: %w", id, err)
+		}
+		if !tweetDeleted(resp) {
+			return deleted, fmt.Errorf("delete %s failed: x did not confirm the deletion", id)
+		}
+		deleted = append(deleted, id)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,242 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	xdk "github.com/missuo/xdk-go"
+)
+
+// Error codes sent in the "code" field of every error response. They are
+// part of the API: clients match on them, never on the message.
+const (
+	codeValidationFailed     = "validation_failed"
+	codeRequestTooLarge      = "request_too_large"
+	codeMediaTooLarge        = "media_too_large"
+	codeUnknownAccount       = "unknown_account"
+	codeAccountNotReady      = "account_not_ready"
+	codeUnauthorized         = "unauthorized"
+	codeForbidden            = "forbidden"
+	codeNotFound             = "not_found"
+	codeConflict             = "conflict"
+	codeIdempotencyKeyReused = "idempotency_key_reused"
+	codeRequestInProgress    = "request_in_progress"
+	codeNotSupported         = "not_supported"
+	codeNotConfigured        = "not_configured"
+	codeQueueFull            = "queue_full"
+	codeInternal             = "internal_error"
+
+	codeXRateLimited      = "x_rate_limited"
+	codeXAuthExpired      = "x_auth_expired"
+	codeXForbidden        = "x_forbidden"
+	codeXDuplicateContent = "x_duplicate_content"
+	codeXInvalidRequest   = "x_invalid_request"
+	codeXNotFound         = "x_not_found"
+	codeXUnavailable      = "x_unavailable"
+	codeXTimeout          = "x_timeout"
+	codeXError            = "x_error"
+)
+
+// xErrorDetail is X's own account of a failed call, passed through so
+// clients see the title and detail X gave.
+type xErrorDetail struct {
+	Status int    `json:"status,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Detail string `json:"detail,omitempty"`
+	Type   string `json:"type,omitempty"`
+
+	// refresh marks a failed OAuth2 token refresh.
+	refresh bool
+}
+
+// mediaSizeError is a file over one of the size or dimension limits.
+type mediaSizeError struct {
+	msg string
+}
+
+func (e *mediaSizeError) Error() string {
+	return e.msg
+}
+
+func newMediaSizeError(format string, args ...any) error {
+	return &mediaSizeError{msg: fmt.Sprintf(format, args...)}
+}
+
+// errorBody is the JSON of an error response.
+func errorBody(code string, message string) gin.H {
+	return gin.H{"error": message, "code": code}
+}
+
+// errorResponse picks the status and body for err. Errors that carry a
+// known meaning, like an X rate limit or an oversized file, get their own
+// code; anything else is reported with status and code.
+func errorResponse(err error, status int, code string) (int, gin.H) {
+	var maxBytesErr *http.MaxBytesError
+	var sizeErr *mediaSizeError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return http.StatusRequestEntityTooLarge, errorBody(codeRequestTooLarge, err.Error())
+	case errors.As(err, &sizeErr):
+		return http.StatusRequestEntityTooLarge, errorBody(codeMediaTooLarge, err.Error())
+	case errors.Is(err, errUnknownAccount):
+		return http.StatusBadRequest, errorBody(codeUnknownAccount, err.Error())
+	}
+
+	if detail, ok := xErrorFrom(err); ok {
+		status, code := xErrorStatus(detail)
+		body := errorBody(code, err.Error())
+		body["x_error"] = detail
+		return status, body
+	}
+	if status >= 500 && errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout, errorBody(codeXTimeout, err.Error())
+	}
+	return status, errorBody(code, err.Error())
+}
+
+// xErrorStatus maps what X said to our status and code.
+func xErrorStatus(detail xErrorDetail) (int, string) {
+	switch status := detail.Status; {
+	case status == http.StatusTooManyRequests:
+		return http.StatusTooManyRequests, codeXRateLimited
+	case status == http.StatusUnauthorized || detail.refresh:
+		// Not 401: that would read as a problem with the caller's API token.
+		return http.StatusServiceUnavailable, codeXAuthExpired
+	case status == http.StatusForbidden && isDuplicateContent(detail):
+		return http.StatusConflict, codeXDuplicateContent
+	case status == http.StatusForbidden:
+		return http.StatusForbidden, codeXForbidden
+	case status == http.StatusNotFound:
+		return http.StatusNotFound, codeXNotFound
+	case status == http.StatusRequestEntityTooLarge:
+		return http.StatusRequestEntityTooLarge, codeMediaTooLarge
+	case status >= 400 && status < 500:
+		return http.StatusBadRequest, codeXInvalidRequest
+	case status >= 500:
+		return http.StatusBadGateway, codeXUnavailable
+	}
+	return http.StatusBadGateway, codeXError
+}
+
+func isDuplicateContent(detail xErrorDetail) bool {
+	return strings.Contains(strings.ToLower(detail.Detail+" "+detail.Title), "duplicate")
+}
+
+// xErrorFrom finds the X API errors inside err. When several upload
+// strategies failed, the one most useful to the caller wins: a rate limit
+// or expired credentials explain the others.
+func xErrorFrom(err error) (xErrorDetail, bool) {
+	var found []xErrorDetail
+	var walk func(err error)
+	walk = func(err error) {
+		if err == nil {
+			return
+		}
+		if apiErr, ok := err.(*xdk.APIError); ok {
+			found = append(found, parseXError(apiErr.StatusCode, apiErr.Body))
+			return
+		}
+		if detail, ok := parseTokenRefreshError(err); ok {
+			found = append(found, detail)
+			return
+		}
+		switch e := err.(type) {
+		case interface{ Unwrap() []error }:
+			for _, inner := range e.Unwrap() {
+				walk(inner)
+			}
+		case interface{ Unwrap() error }:
+			walk(e.Unwrap())
+		}
+	}
+	walk(err)
+	if len(found) == 0 {
+		return xErrorDetail{}, false
+	}
+
+	rank := func(d xErrorDetail) int {
+		switch {
+		case d.Status == http.StatusTooManyRequests:
+			return 4
+		case d.Status == http.StatusUnauthorized || d.refresh:
+			return 3
+		case d.Status >= 500:
+			return 1
+		case d.Status >= 400:
+			return 2
+		}
+		return 0
+	}
+	best := found[0]
+	for _, d := range found[1:] {
+		if rank(d) > rank(best) {
+			best = d
+		}
+	}
+	return best, true
+}
+
+// parseXError reads the title and detail from an X error body. v2 puts them
+// at the top level, v1.1 and the media endpoints in an errors array, and the
+// OAuth2 token endpoint uses error and error_description.
+func parseXError(status int, body string) xErrorDetail {
+	detail := xErrorDetail{Status: status}
+	var doc struct {
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+		Type   string `json:"type"`
+		Errors []struct {
+			Title   string `json:"title"`
+			Detail  string `json:"detail"`
+			Message string `json:"message"`
+			Type    string `json:"type"`
+		} `json:"errors"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if json.Unmarshal([]byte(body), &doc) != nil {
+		detail.Detail = strings.TrimSpace(body)
+		return detail
+	}
+	detail.Title, detail.Detail, detail.Type = doc.Title, doc.Detail, doc.Type
+	if len(doc.Errors) > 0 {
+		first := doc.Errors[0]
+		detail.Title = cmp.Or(detail.Title, first.Title)
+		detail.Detail = cmp.Or(detail.Detail, first.Detail, first.Message)
+		detail.Type = cmp.Or(detail.Type, first.Type)
+	}
+	detail.Title = cmp.Or(detail.Title, doc.Error)
+	detail.Detail = cmp.Or(detail.Detail, doc.ErrorDescription)
+	return detail
+}
+
+// parseTokenRefreshError recognizes a failed OAuth2 refresh. xdk reports it
+// as a plain error, so its message is the only thing to go on.
+func parseTokenRefreshError(err error) (xErrorDetail, bool) {
+	msg := err.Error()
+	switch {
+	case strings.HasPrefix(msg, "token refresh failed: "):
+		var status int
+		var body string
+		if _, scanErr := fmt.Sscanf(msg, "token refresh failed: status=%d body=", &status); scanErr != nil {
+			return xErrorDetail{}, false
+		}
+		if _, rest, ok := strings.Cut(msg, " body="); ok {
+			body = rest
+		}
+		detail := parseXError(status, body)
+		// An unusable refresh token means the login has to be redone, no
+		// matter which status the token endpoint chose.
+		detail.refresh = status >= 400 && status < 500
+		return detail, true
+	case msg == "refresh_token is missing" || msg == "no token to refresh":
+		return xErrorDetail{Detail: msg, refresh: true}, true
+	}
+	return xErrorDetail{}, false
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	xdk "github.com/missuo/xdk-go"
+)
+
+func TestXErrorStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		detail     xErrorDetail
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "rate limited", detail: xErrorDetail{Status: 429}, wantStatus: http.StatusTooManyRequests, wantCode: codeXRateLimited},
+		{name: "unauthorized", detail: xErrorDetail{Status: 401}, wantStatus: http.StatusServiceUnavailable, wantCode: codeXAuthExpired},
+		{name: "refresh rejected", detail: xErrorDetail{Status: 400, refresh: true}, wantStatus: http.StatusServiceUnavailable, wantCode: codeXAuthExpired},
+		{name: "refresh token missing", detail: xErrorDetail{refresh: true}, wantStatus: http.StatusServiceUnavailable, wantCode: codeXAuthExpired},
+		{name: "duplicate", detail: xErrorDetail{Status: 403, Detail: "You are not allowed to create a Tweet with duplicate content."}, wantStatus: http.StatusConflict, wantCode: codeXDuplicateContent},
+		{name: "duplicate in title", detail: xErrorDetail{Status: 403, Title: "Duplicate Tweet"}, wantStatus: http.StatusConflict, wantCode: codeXDuplicateContent},
+		{name: "forbidden", detail: xErrorDetail{Status: 403, Detail: "not permitted"}, wantStatus: http.StatusForbidden, wantCode: codeXForbidden},
+		{name: "not found", detail: xErrorDetail{Status: 404}, wantStatus: http.StatusNotFound, wantCode: codeXNotFound},
+		{name: "too large", detail: xErrorDetail{Status: 413}, wantStatus: http.StatusRequestEntityTooLarge, wantCode: codeMediaTooLarge},
+		{name: "invalid", detail: xErrorDetail{Status: 400}, wantStatus: http.StatusBadRequest, wantCode: codeXInvalidRequest},
+		{name: "unavailable", detail: xErrorDetail{Status: 503}, wantStatus: http.StatusBadGateway, wantCode: codeXUnavailable},
+		{name: "no status", detail: xErrorDetail{}, wantStatus: http.StatusBadGateway, wantCode: codeXError},
+	}
+	for _, tt := range tests {
+		status, code := xErrorStatus(tt.detail)
+		if status != tt.wantStatus || code != tt.wantCode {
+			t.Errorf("%s: %d %s, want %d %s", tt.name, status, code, tt.wantStatus, tt.wantCode)
+		}
+	}
+}
+
+func TestParseTokenRefreshError(t *testing.T) {
+	tests := []struct {
+		msg         string
+		ok          bool
+		wantStatus  int
+		wantTitle   string
+		wantDetail  string
+		wantRefresh bool
+	}{
+		{
+			msg:         `token refresh failed: status=400 body={"error": "invalid_grant", "error_description": "Value passed for the token was invalid."}`,
+			ok:          true,
+			wantStatus:  400,
+			wantTitle:   "invalid_grant",
+			wantDetail:  "Value passed for the token was invalid.",
+			wantRefresh: true,
+		},
+		{
+			msg:         `token refresh failed: status=401 body=unauthorized`,
+			ok:          true,
+			wantStatus:  401,
+			wantDetail:  "unauthorized",
+			wantRefresh: true,
+		},
+		{
+			// X being down is not a reason to log in again.
+			msg:        `token refresh failed: status=503 body={"title": "Service Unavailable"}`,
+			ok:         true,
+			wantStatus: 503,
+			wantTitle:  "Service Unavailable",
+		},
+		{msg: "refresh_token is missing", ok: true, wantDetail: "refresh_token is missing", wantRefresh: true},
+		{msg: "no token to refresh", ok: true, wantDetail: "no token to refresh", wantRefresh: true},
+		{msg: "token refresh failed: connection refused"},
+		{msg: "x api error: status=401 body={}"},
+	}
+	for _, tt := range tests {
+		detail, ok := parseTokenRefreshError(errors.New(tt.msg))
+		if ok != tt.ok {
+			t.Errorf("%q: recognized %v, want %v", tt.msg, ok, tt.ok)
+			continue
+		}
+		if detail.Status != tt.wantStatus || detail.Title != tt.wantTitle || detail.Detail != tt.wantDetail || detail.refresh != tt.wantRefresh {
+			t.Errorf("%q: %+v", tt.msg, detail)
+		}
+	}
+}
+
+func TestErrorResponseFromXError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{
+			name:       "wrapped duplicate",
+			err:        fmt.Errorf("create post: %w", &xdk.APIError{StatusCode: 403, Body: `{"detail": "duplicate content"}`}),
+			wantStatus: http.StatusConflict,
+			wantCode:   codeXDuplicateContent,
+		},
+		{
+			name:       "refresh failed",
+			err:        fmt.Errorf("upload media: %w", errors.New(`token refresh failed: status=400 body={"error": "invalid_grant"}`)),
+			wantStatus: http.StatusServiceUnavailable,
+			wantCode:   codeXAuthExpired,
+		},
+		{
+			// The rate limit explains why the other upload path failed too.
+			name: "rate limit wins",
+			err: errors.Join(
+				&xdk.APIError{StatusCode: 503, Body: `{"title": "Service Unavailable"}`},
+				&xdk.APIError{StatusCode: 429, Body: `{"title": "Too Many Requests"}`},
+			),
+			wantStatus: http.StatusTooManyRequests,
+			wantCode:   codeXRateLimited,
+		},
+		{name: "timeout", err: context.DeadlineExceeded, wantStatus: http.StatusGatewayTimeout, wantCode: codeXTimeout},
+		{name: "other", err: errors.New("boom"), wantStatus: http.StatusBadGateway, wantCode: codeXError},
+	}
+	for _, tt := range tests {
+		status, body := errorResponse(tt.err, http.StatusBadGateway, codeXError)
+		if status != tt.wantStatus || body["code"] != tt.wantCode {
+			t.Errorf("%s: %d %v, want %d %s", tt.name, status, body["code"], tt.wantStatus, tt.wantCode)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package app
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	idempotencyKeyHeader    = "Idempotency-Key"
+	idempotencyReplayHeader = "Idempotent-Replayed"
+	defaultIdempotencyTTL   = 24 * time.Hour
+	idempotencyCacheSize    = 1000
+	maxIdempotencyKeyLength = 255
+	idempotencyMismatch     = "Idempotency-Key was already used with a different request"
+
+	idempotencyClaimContextKey = "xpost.idempotency_claim"
+	idempotencyKeepContextKey  = "xpost.idempotency_keep"
+)
+
+type idempotencyRecord struct {
+	// Key is the API token ID and the client's key, so two clients cannot
+	// see each other's responses.
+	Key         string    `json:"key"`
+	Fingerprint string    `json:"fingerprint"`
+	Status      int       `json:"status"`
+	Body        string    `json:"body"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type idempotencyFile struct {
+	Records []idempotencyRecord `json:"records"`
+}
+
+// idempotencyStore remembers successful responses by Idempotency-Key for ttl.
+// Recent keys live in an in-memory LRU; with a file the records also go to
+// idempotency.json next to the config, so they survive a restart.
+type idempotencyStore struct {
+	ttl  time.Duration
+	file *jsonStore
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+	pending map[string]string
+}
+
+// newIdempotencyStore keeps records in memory only when dir is empty.
+func newIdempotencyStore(ttl time.Duration, dir string) *idempotencyStore {
+	s := &idempotencyStore{
+		ttl:     ttl,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+		pending: map[string]string{},
+	}
+	if dir != "" {
+		s.file = newJSONStore(filepath.Join(dir, "idempotency.json"))
+	}
+	return s
+}
+
+// parseIdempotencyTTL accepts a duration such as "24h" or "7d".
+func parseIdempotencyTTL(raw string) (time.Duration, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return defaultIdempotencyTTL, nil
+	}
+	if days, ok := strings.CutSuffix(raw, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	}
+	if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("invalid idempotency_ttl %q, use a duration like 24h or 7d", raw)
+}
+
+// lookup must be called with s.mu held.
+func (s *idempotencyStore) lookup(key string, now time.Time) (idempotencyRecord, bool) {
+	if el, ok := s.entries[key]; ok {
+		rec := el.Value.(idempotencyRecord)
+		if now.Before(rec.ExpiresAt) {
+			s.order.MoveToFront(el)
+			return rec, true
+		}
+		s.order.Remove(el)
+		delete(s.entries, key)
+		return idempotencyRecord{}, false
+	}
+	if s.file == nil {
+		return idempotencyRecord{}, false
+	}
+	var doc idempotencyFile
+	if err := s.file.load(&doc); err != nil {
+		log.Printf("warning: failed to read idempotency store: %v", err)
+		return idempotencyRecord{}, false
+	}
+	for _, rec := range doc.Records {
+		if rec.Key == key && now.Before(rec.ExpiresAt) {
+			s.remember(rec)
+			return rec, true
+		}
+	}
+	return idempotencyRecord{}, false
+}
+
+// remember must be called with s.mu held.
+func (s *idempotencyStore) remember(rec idempotencyRecord) {
+	if el, ok := s.entries[rec.Key]; ok {
+		el.Value = rec
+		s.order.MoveToFront(el)
+		return
+	}
+	s.entries[rec.Key] = s.order.PushFront(rec)
+	for s.order.Len() > idempotencyCacheSize {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(idempotencyRecord).Key)
+	}
+}
+
+func (s *idempotencyStore) save(rec idempotencyRecord) {
+	s.mu.Lock()
+	s.remember(rec)
+	s.mu.Unlock()
+	if s.file == nil {
+		return
+	}
+	var doc idempotencyFile
+	err := s.file.update(&doc, func() error {
+		kept := doc.Records[:0]
+		for _, old := range doc.Records {
+			if old.Key != rec.Key && rec.CreatedAt.Before(old.ExpiresAt) {
+				kept = append(kept, old)
+			}
+		}
+		doc.Records = append(kept, rec)
+		return nil
+	})
+	if err != nil {
+		log.Printf("warning: failed to write idempotency store: %v", err)
+	}
+}
+
+// begin handles the Idempotency-Key header of c. A repeated key with the
+// same fingerprint gets the stored response, a different one gets 409; in
+// both cases ok is false and the handler should return. Otherwise the caller
+// runs the request and then calls finish, which stores 2xx responses and
+// those marked with keepIdempotentResponse. Requests without the header, or
+// a nil store, pass straight through.
+func (s *idempotencyStore) begin(c *gin.Context, fingerprint func() (string, error)) (finish func(), ok bool) {
+	key := strings.TrimSpace(c.GetHeader(idempotencyKeyHeader))
+	if s == nil || key == "" {
+		return func() {}, true
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		c.JSON(http.StatusBadRequest, errorBody(codeValidationFailed, fmt.Sprintf("Idempotency-Key is longer than %d characters", maxIdempotencyKeyLength)))
+		return nil, false
+	}
+	fp, err := fingerprint()
+	if err != nil {
+		c.JSON(errorResponse(err, http.StatusBadRequest, codeValidationFailed))
+		return nil, false
+	}
+	scoped := requestAPIToken(c).ID + ":" + key
+
+	s.mu.Lock()
+	if pendingFP, busy := s.pending[scoped]; busy {
+		s.mu.Unlock()
+		if pendingFP != fp {
+			c.JSON(http.StatusConflict, errorBody(codeIdempotencyKeyReused, idempotencyMismatch))
+			return nil, false
+		}
+		c.JSON(http.StatusConflict, errorBody(codeRequestInProgress, "a request with this Idempotency-Key is still in progress"))
+		return nil, false
+	}
+	now := time.Now().UTC()
+	if rec, found := s.lookup(scoped, now); found {
+		s.mu.Unlock()
+		if rec.Fingerprint != fp {
+			c.JSON(http.StatusConflict, errorBody(codeIdempotencyKeyReused, idempotencyMismatch))
+			return nil, false
+		}
+		c.Header(idempotencyReplayHeader, "true")
+		c.Data(rec.Status, "application/json; charset=utf-8", []byte(rec.Body))
+		return nil, false
+	}
+	s.pending[scoped] = fp
+	s.mu.Unlock()
+	c.Set(idempotencyClaimContextKey, true)
+
+	w := &recordingWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	return func() {
+		// The key stays pending until the record is saved, so a retry
+		// arriving in between cannot run the request again.
+		defer func() {
+			s.mu.Lock()
+			delete(s.pending, scoped)
+			s.mu.Unlock()
+		}()
+		status := w.Status()
+		if (status < 200 || status > 299) && !c.GetBool(idempotencyKeepContextKey) {
+			return
+		}
+		s.save(idempotencyRecord{
+			Key:         scoped,
+			Fingerprint: fp,
+			Status:      status,
+			Body:        w.body.String(),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(s.ttl),
+		})
+	}, true
+}
+
+// idempotencyClaimed reports whether c holds an Idempotency-Key. Its work
+// should then finish even if the client goes away, so the retry finds the
+// outcome instead of posting again.
+func idempotencyClaimed(c *gin.Context) bool {
+	return c.GetBool(idempotencyClaimContextKey)
+}
+
+// keepIdempotentResponse stores the response of c under its key even though
+// it is an error, because the post may have gone out anyway.
+func keepIdempotentResponse(c *gin.Context) {
+	c.Set(idempotencyKeepContextKey, true)
+}
+
+// recordingWriter keeps a copy of the response body.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// fingerprint identifies what a create-tweet request would post, so retries
+// match even when JSON formatting or multipart boundaries differ.
+func (r tweetRequest) fingerprint() (string, error) {
+	type mediaPrint struct {
+		SHA256      string `json:"sha256"`
+		ContentType string `json:"content_type"`
+		AltText     string `json:"alt_text,omitempty"`
+	}
+	media := make([]mediaPrint, 0, len(r.Media))
+	for _, input := range r.Media {
+		sum, err := fileSHA256(input)
+		if err != nil {
+			return "", err
+		}
+		media = append(media, mediaPrint{SHA256: sum, ContentType: input.ContentType, AltText: input.AltText})
+	}
+	b, err := json.Marshal(struct {
+		Request     tweetRequest `json:"request"`
+		Media       []mediaPrint `json:"media"`
+		MediaURLs   []mediaURL   `json:"media_urls"`
+		PublishAt   time.Time    `json:"publish_at"`
+		ThreadParts []string     `json:"thread_parts"`
+		Async       bool         `json:"async"`
+	}{r, media, r.MediaURLs, r.PublishAt, r.threadParts, r.async})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,214 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// idempotentRouter runs begin for every request. The fingerprint comes from
+// the fp query parameter; status and keep make it fail and keep the error.
+// A handler asked to hold reports on started and waits for release.
+func idempotentRouter(store *idempotencyStore, started chan<- struct{}, release <-chan struct{}, runs *int) http.Handler {
+	r := gin.New()
+	r.POST("/", func(c *gin.Context) {
+		finish, ok := store.begin(c, func() (string, error) { return c.Query("fp"), nil })
+		if !ok {
+			return
+		}
+		defer finish()
+		*runs++
+		if c.Query("hold") != "" {
+			started <- struct{}{}
+			<-release
+		}
+		if c.Query("keep") != "" {
+			keepIdempotentResponse(c)
+		}
+		status := http.StatusOK
+		if c.Query("status") != "" {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, gin.H{"run": *runs})
+	})
+	return r
+}
+
+func idempotentPost(h http.Handler, key, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/?"+query, nil)
+	if key != "" {
+		req.Header.Set(idempotencyKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func errorCode(w *httptest.ResponseRecorder) string {
+	var body struct {
+		Code string `json:"code"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	return body.Code
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	tests := []struct {
+		name       string
+		first      string
+		retry      string
+		wantStatus int
+		wantCode   string
+		wantReplay bool
+		wantRuns   int
+	}{
+		{name: "same request", first: "fp=a", retry: "fp=a", wantStatus: http.StatusOK, wantReplay: true, wantRuns: 1},
+		{name: "different request", first: "fp=a", retry: "fp=b", wantStatus: http.StatusConflict, wantCode: codeIdempotencyKeyReused, wantRuns: 1},
+		{name: "error not stored", first: "fp=a&status=503", retry: "fp=a", wantStatus: http.StatusOK, wantRuns: 2},
+		{name: "may have posted", first: "fp=a&status=503&keep=1", retry: "fp=a", wantStatus: http.StatusServiceUnavailable, wantReplay: true, wantRuns: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runs := 0
+			h := idempotentRouter(newIdempotencyStore(time.Hour, ""), nil, nil, &runs)
+			first := idempotentPost(h, "k", tt.first)
+			w := idempotentPost(h, "k", tt.retry)
+			if w.Code != tt.wantStatus || errorCode(w) != tt.wantCode || runs != tt.wantRuns {
+				t.Fatalf("retry: %d %s after %d runs, want %d %q after %d", w.Code, w.Body, runs, tt.wantStatus, tt.wantCode, tt.wantRuns)
+			}
+			if replayed := w.Header().Get(idempotencyReplayHeader) == "true"; replayed != tt.wantReplay {
+				t.Errorf("replayed %v, want %v", replayed, tt.wantReplay)
+			}
+			if tt.wantReplay && w.Body.String() != first.Body.String() {
+				t.Errorf("replayed %s, want the first response %s", w.Body, first.Body)
+			}
+		})
+	}
+}
+
+func TestIdempotencyPending(t *testing.T) {
+	runs := 0
+	started, release := make(chan struct{}), make(chan struct{})
+	h := idempotentRouter(newIdempotencyStore(time.Hour, ""), started, release, &runs)
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- idempotentPost(h, "k", "fp=a&hold=1") }()
+	<-started
+
+	if w := idempotentPost(h, "k", "fp=a"); w.Code != http.StatusConflict || errorCode(w) != codeRequestInProgress {
+		t.Errorf("same request while pending: %d %s, want 409 %s", w.Code, w.Body, codeRequestInProgress)
+	}
+	if w := idempotentPost(h, "k", "fp=b"); w.Code != http.StatusConflict || errorCode(w) != codeIdempotencyKeyReused {
+		t.Errorf("different request while pending: %d %s, want 409 %s", w.Code, w.Body, codeIdempotencyKeyReused)
+	}
+	if w := idempotentPost(h, "other", "fp=a"); w.Code != http.StatusOK {
+		t.Errorf("another key while pending: %d %s", w.Code, w.Body)
+	}
+	close(release)
+	<-done
+
+	if w := idempotentPost(h, "k", "fp=a"); w.Header().Get(idempotencyReplayHeader) != "true" {
+		t.Errorf("after the first request finished: %d %s, want its response", w.Code, w.Body)
+	}
+	if runs != 2 {
+		t.Errorf("handler ran %d times, want 2", runs)
+	}
+}
+
+func TestIdempotencySurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	runs := 0
+	first := idempotentPost(idempotentRouter(newIdempotencyStore(time.Hour, dir), nil, nil, &runs), "k", "fp=a&status=503&keep=1")
+
+	w := idempotentPost(idempotentRouter(newIdempotencyStore(time.Hour, dir), nil, nil, &runs), "k", "fp=a")
+	if runs != 1 || w.Body.String() != first.Body.String() || w.Code != http.StatusServiceUnavailable {
+		t.Errorf("after a restart: %d %s, want the stored %s", w.Code, w.Body, first.Body)
+	}
+}
+
+func TestTweetRequestFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	media := func(name, content string) mediaUploadInput {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return mediaUploadInput{Path: path, ContentType: "image/png"}
+	}
+	base := tweetRequest{Text: "hello", Media: []mediaUploadInput{media("a.png", "one")}}
+	want, err := base.fingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	same := base
+	same.Media = []mediaUploadInput{media("b.png", "one")}
+	longer := base
+	longer.Text = "hello!"
+	other := base
+	other.Media = []mediaUploadInput{media("c.png", "two")}
+	thread := base
+	thread.threadParts = []string{"hello", "again"}
+	later := base
+	later.PublishAt = time.Now()
+
+	tests := []struct {
+		name string
+		req  tweetRequest
+		same bool
+	}{
+		{name: "same file under another name", req: same, same: true},
+		{name: "different text", req: longer},
+		{name: "different media", req: other},
+		{name: "split into a thread", req: thread},
+		{name: "scheduled", req: later},
+	}
+	for _, tt := range tests {
+		got, err := tt.req.fingerprint()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if (got == want) != tt.same {
+			t.Errorf("%s: fingerprint matches %v, want %v", tt.name, got == want, tt.same)
+		}
+	}
+}
+
+func TestCreateTweetStoresMayHavePosted(t *testing.T) {
+	x := &stubX{respond: func(w http.ResponseWriter, call int) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"title": "Service Unavailable", "detail": "try again"}`))
+	}}
+	h := newRouter(&App{
+		cfg:         &Config{Security: SecurityConfig{envToken: "t"}},
+		posters:     map[string]*Poster{defaultAccountName: stubPoster(t, x)},
+		idempotency: newIdempotencyStore(time.Hour, ""),
+	})
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/tweets", strings.NewReader(`{"text": "hello"}`))
+		req.Header.Set("Authorization", "Bearer t")
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(idempotencyKeyHeader, "k")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	first := post()
+	if !strings.Contains(first.Body.String(), `"may_have_posted":true`) {
+		t.Fatalf("first attempt: %d %s, want may_have_posted", first.Code, first.Body)
+	}
+	retry := post()
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() || retry.Header().Get(idempotencyReplayHeader) != "true" {
+		t.Errorf("retry: %d %s, want the stored response", retry.Code, retry.Body)
+	}
+	if len(x.replyTo) != 1 {
+		t.Errorf("create post sent %d times, want once", len(x.replyTo))
+	}
+}
@@ -0,0 +1,176 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	jobQueued     = "queued"
+	jobUploading  = "uploading"
+	jobProcessing = "processing"
+	jobPosted     = "posted"
+	jobFailed     = "failed"
+
+	jobWorkers   = 4
+	jobQueueSize = 100
+	// jobRetention is how long finished jobs can still be polled.
+	jobRetention = time.Hour
+)
+
+var errJobQueueFull = errors.New("job queue is full, try again later")
+
+// postJob is the pollable state of an async=true post.
+type postJob struct {
+	ID        string    `json:"id"`
+	State     string    `json:"state"`
+	Account   string    `json:"account"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Result is the response body the synchronous request would have had.
+	Result gin.H  `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Code   string `json:"code,omitempty"`
+}
+
+type jobTask struct {
+	id      string
+	timeout time.Duration
+	run     func(ctx context.Context) (int, gin.H)
+	cleanup func()
+}
+
+// jobQueue runs posts in the background on a fixed pool of workers. Jobs are
+// kept in memory only, like the spooled media they depend on.
+type jobQueue struct {
+	mu    sync.Mutex
+	jobs  map[string]*postJob
+	tasks chan jobTask
+}
+
+func newJobQueue(workers int) *jobQueue {
+	q := &jobQueue{
+		jobs:  map[string]*postJob{},
+		tasks: make(chan jobTask, jobQueueSize),
+	}
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+// submit queues run for account. cleanup runs once the job is done, or right
+// away when the queue is full.
+func (q *jobQueue) submit(account string, timeout time.Duration, run func(ctx context.Context) (int, gin.H), cleanup func()) (postJob, error) {
+	id := newRecordID()
+	now := time.Now().UTC()
+	job := &postJob{ID: id, State: jobQueued, Account: account, CreatedAt: now, UpdatedAt: now}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.prune(now)
+	select {
+	case q.tasks <- jobTask{id: id, timeout: timeout, run: run, cleanup: cleanup}:
+	default:
+		cleanup()
+		return postJob{}, errJobQueueFull
+	}
+	q.jobs[id] = job
+	return *job, nil
+}
+
+// prune must be called with q.mu held.
+func (q *jobQueue) prune(now time.Time) {
+	for id, job := range q.jobs {
+		done := job.State == jobPosted || job.State == jobFailed
+		if done && now.Sub(job.UpdatedAt) > jobRetention {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+func (q *jobQueue) get(id string) (postJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return postJob{}, false
+	}
+	return *job, true
+}
+
+func (q *jobQueue) update(id string, fn func(job *postJob)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if job, ok := q.jobs[id]; ok {
+		fn(job)
+		job.UpdatedAt = time.Now().UTC()
+	}
+}
+
+func (q *jobQueue) setState(id, state string) {
+	q.update(id, func(job *postJob) {
+		job.State = state
+	})
+}
+
+func (q *jobQueue) work() {
+	for task := range q.tasks {
+		q.run(task)
+	}
+}
+
+func (q *jobQueue) run(task jobTask) {
+	defer task.cleanup()
+	q.setState(task.id, jobUploading)
+
+	ctx, cancel := context.WithTimeout(context.Background(), task.timeout)
+	defer cancel()
+	ctx = withJobProgress(ctx, func(state string) { q.setState(task.id, state) })
+
+	status, body := task.run(ctx)
+	q.update(task.id, func(job *postJob) {
+		job.Result = body
+		if status >= 200 && status <= 299 {
+			job.State = jobPosted
+			return
+		}
+		job.State = jobFailed
+		job.Error, _ = body["error"].(string)
+		job.Code, _ = body["code"].(string)
+	})
+}
+
+type jobProgressKey struct{}
+
+func withJobProgress(ctx context.Context, fn func(state string)) context.Context {
+	return context.WithValue(ctx, jobProgressKey{}, fn)
+}
+
+// reportJobState updates the job running under ctx, if any.
+func reportJobState(ctx context.Context, state string) {
+	if fn, ok := ctx.Value(jobProgressKey{}).(func(string)); ok {
+		fn(state)
+	}
+}
+
+func (a *App) handleGetJob(c *gin.Context) {
+	if a.jobs == nil {
+		c.JSON(http.StatusNotFound, errorBody(codeNotFound, "job not found"))
+		return
+	}
+	job, ok := a.jobs.get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, errorBody(codeNotFound, "job not found"))
+		return
+	}
+	if !checkAccountAllowed(c, job.Account) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "job": job})
+}
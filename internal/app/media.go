@@ -0,0 +1,523 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	xdk "github.com/missuo/xdk-go"
+)
+
+// Size limits per media category, matching what X accepts.
+const (
+	maxImageBytes = 5 * 1024 * 1024
+	maxGIFBytes   = 15 * 1024 * 1024
+	maxVideoBytes = 512 * 1024 * 1024
+)
+
+const (
+	mediaCategoryImage = "tweet_image"
+	mediaCategoryGIF   = "tweet_gif"
+	mediaCategoryVideo = "tweet_video"
+)
+
+const (
+	mediaChunkSize         = 4 * 1024 * 1024
+	mediaStatusMinInterval = time.Second
+	mediaStatusMaxInterval = 30 * time.Second
+
+	// Requests carrying video or GIFs get longer than the usual 90 seconds,
+	// since X may need minutes to transcode them.
+	postTimeout      = 90 * time.Second
+	mediaPostTimeout = 15 * time.Minute
+)
+
+func mediaCategoryFromType(contentType string) string {
+	ct := strings.ToLower(strings.TrimSpace(contentType))
+	switch {
+	case ct == "image/gif":
+		return mediaCategoryGIF
+	case strings.HasPrefix(ct, "image/"):
+		return mediaCategoryImage
+	case strings.HasPrefix(ct, "video/"):
+		return mediaCategoryVideo
+	case strings.HasPrefix(ct, "audio/"):
+		return mediaCategoryVideo
+	default:
+		return mediaCategoryImage
+	}
+}
+
+func maxMediaBytesFor(category string) int64 {
+	switch category {
+	case mediaCategoryVideo:
+		return maxVideoBytes
+	case mediaCategoryGIF:
+		return maxGIFBytes
+	default:
+		return maxImageBytes
+	}
+}
+
+// maxSourceBytesFor is the limit for incoming media. Images may be larger
+// than X accepts, since prepareMedia can shrink them.
+func maxSourceBytesFor(category string) int64 {
+	if category == mediaCategoryImage {
+		return maxImageSourceBytes
+	}
+	return maxMediaBytesFor(category)
+}
+
+// checkMediaSize applies X's limit for the category. name identifies the
+// item in the error message.
+func checkMediaSize(name string, size int64, category string) error {
+	if limit := maxMediaBytesFor(category); size > limit {
+		return newMediaSizeError("%s is %d bytes, max for %s is %d bytes", name, size, category, limit)
+	}
+	return nil
+}
+
+func checkSourceSize(name string, size int64, category string) error {
+	if limit := maxSourceBytesFor(category); size > limit {
+		return newMediaSizeError("%s is %d bytes, max accepted for %s is %d bytes", name, size, category, limit)
+	}
+	return nil
+}
+
+// uploadTimeout picks the context timeout for a post with the given media.
+func uploadTimeout(inputs ...[]mediaUploadInput) time.Duration {
+	for _, group := range inputs {
+		for _, input := range group {
+			if input.category() != mediaCategoryImage {
+				return mediaPostTimeout
+			}
+		}
+	}
+	return postTimeout
+}
+
+// uploadMediaInputs uploads inputs in order, reusing IDs from lib for bytes
+// that were uploaded before. lib may be nil.
+func uploadMediaInputs(ctx context.Context, poster *Poster, lib *mediaLibrary, inputs []mediaUploadInput) ([]MediaRef, error) {
+	uploaded := make([]MediaRef, 0, len(inputs))
+	for _, input := range inputs {
+		ref, err := lib.upload(ctx, poster, input)
+		if err != nil {
+			return nil, err
+		}
+		ref.Transforms = input.Transforms
+		uploaded = append(uploaded, ref)
+	}
+	return uploaded, nil
+}
+
+// uploadWithAltText uploads input and then attaches its alt text, so the
+// media is only recorded for reuse once both have succeeded.
+func uploadWithAltText(ctx context.Context, poster *Poster, input mediaUploadInput) (MediaRef, error) {
+	ref, err := poster.UploadMedia(ctx, input)
+	if err != nil {
+		return MediaRef{}, err
+	}
+	if input.AltText != "" {
+		if err := poster.SetMediaAltText(ctx, ref.ID, input.AltText); err != nil {
+			return MediaRef{}, err
+		}
+		ref.AltText = input.AltText
+	}
+	return ref, nil
+}
+
+// SetMediaAltText attaches alt text to uploaded media through the media
+// metadata endpoint. It has to run before the media is used in a post.
+func (p *Poster) SetMediaAltText(ctx context.Context, mediaID, altText string) error {
+	if mediaID == "" {
+		return errors.New("alt text failed: upload returned no media id")
+	}
+	_, err := withRetry(ctx, retrySafe, func(ctx context.Context) (xdk.JSON, error) {
+		return p.client.Media.CreateMetadata(ctx, xdk.Params{
+			"body": map[string]any{
+				"id": mediaID,
+				"metadata": map[string]any{
+					"alt_text": map[string]any{"text": altText},
+				},
+			},
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("alt text for media %s failed: %w", mediaID, err)
+	}
+	return nil
+}
+
+// UploadMedia uploads one attachment. Images go through the one-shot upload
+// first; videos and GIFs always use the chunked flow, which waits for X to
+// finish processing so the media can be attached right away. Only images,
+// capped at maxImageBytes, are ever read into memory whole.
+func (p *Poster) UploadMedia(ctx context.Context, input mediaUploadInput) (MediaRef, error) {
+	contentType := input.ContentType
+	mediaCategory := input.category()
+	if mediaCategory != mediaCategoryImage {
+		ref, err := p.uploadMediaChunked(ctx, input)
+		countMediaUpload("chunked", err)
+		if err != nil {
+			return MediaRef{}, &mediaUploadError{attempts: []error{fmt.Errorf("chunked upload: %w", err)}}
+		}
+		return ref, nil
+	}
+
+	data, err := readMediaFile(input, maxImageBytes)
+	if err != nil {
+		return MediaRef{}, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+	attemptBodies := []map[string]any{
+		{
+			"media":          encoded,
+			"media_type":     contentType,
+			"media_category": mediaCategory,
+		},
+		{
+			"media_data":     encoded,
+			"media_type":     contentType,
+			"media_category": mediaCategory,
+		},
+	}
+
+	var errs []error
+	for _, body := range attemptBodies {
+		resp, err := withRetry(ctx, retrySafe, func(ctx context.Context) (xdk.JSON, error) {
+			return p.client.Media.Upload(ctx, xdk.Params{"body": body})
+		})
+		if err != nil {
+			countMediaUpload("simple", err)
+			errs = append(errs, fmt.Errorf("simple upload: %w", err))
+			continue
+		}
+		ref := extractMediaRef(resp)
+		if ref.ID != "" || ref.MediaKey != "" {
+			countMediaUpload("simple", nil)
+			return ref, nil
+		}
+		err = errors.New("simple upload: no media identifier returned")
+		countMediaUpload("simple", err)
+		errs = append(errs, err)
+	}
+
+	ref, err := p.uploadMediaChunked(ctx, input)
+	countMediaUpload("chunked", err)
+	if err == nil {
+		return ref, nil
+	}
+	errs = append(errs, fmt.Errorf("chunked upload: %w", err))
+
+	if p.client != nil && p.client.Auth != nil {
+		ref, err = withRetry(ctx, retrySafe, func(ctx context.Context) (MediaRef, error) {
+			return p.uploadMediaV1(ctx, input)
+		})
+		countMediaUpload("v1", err)
+		if err == nil {
+			return ref, nil
+		}
+		errs = append(errs, fmt.Errorf("v1 upload: %w", err))
+	}
+
+	return MediaRef{}, &mediaUploadError{attempts: errs}
+}
+
+// countMediaUpload records one try of an upload strategy in
+// xpost_media_upload_attempts_total.
+func countMediaUpload(strategy string, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	metricMediaUploads.inc(strategy, result)
+}
+
+// mediaUploadError holds why each upload strategy failed. Unwrap exposes
+// all of them, so the X error behind any attempt can be found.
+type mediaUploadError struct {
+	attempts []error
+}
+
+func (e *mediaUploadError) Error() string {
+	msgs := make([]string, 0, len(e.attempts))
+	for _, err := range e.attempts {
+		msgs = append(msgs, err.Error())
+	}
+	return "media upload failed: " + strings.Join(msgs, "; ")
+}
+
+func (e *mediaUploadError) Unwrap() []error {
+	return e.attempts
+}
+
+// uploadMediaChunked runs INIT, one APPEND per mediaChunkSize segment, and
+// FINALIZE, then polls STATUS until X reports the media as usable.
+func (p *Poster) uploadMediaChunked(ctx context.Context, input mediaUploadInput) (MediaRef, error) {
+	f, err := input.Open()
+	if err != nil {
+		return MediaRef{}, err
+	}
+	defer f.Close()
+
+	initResp, err := withRetry(ctx, retrySafe, func(ctx context.Context) (xdk.JSON, error) {
+		return p.client.Media.InitializeUpload(ctx, xdk.Params{
+			"body": map[string]any{
+				"total_bytes":    input.Size,
+				"media_type":     input.ContentType,
+				"media_category": input.category(),
+			},
+		})
+	})
+	if err != nil {
+		return MediaRef{}, err
+	}
+
+	initRef := extractMediaRef(initResp)
+	mediaID := initRef.ID
+	if mediaID == "" {
+		return MediaRef{}, errors.New("initialize_upload did not return media id")
+	}
+
+	// X has accepted both "media" and "media_data" for the segment payload;
+	// settle on whichever works for the first segment.
+	fields := []string{"media", "media_data"}
+	chunk := make([]byte, mediaChunkSize)
+	for index := 0; ; index++ {
+		n, readErr := io.ReadFull(f, chunk)
+		if n == 0 {
+			if errors.Is(readErr, io.EOF) {
+				break
+			}
+			return MediaRef{}, fmt.Errorf("failed to read media: %w", readErr)
+		}
+		if readErr != nil && !errors.Is(readErr, io.ErrUnexpectedEOF) {
+			return MediaRef{}, fmt.Errorf("failed to read media: %w", readErr)
+		}
+		segment := base64.StdEncoding.EncodeToString(chunk[:n])
+
+		var appendErr error
+		for i, field := range fields {
+			appendErr = p.appendMediaSegment(ctx, mediaID, index, field, segment)
+			if appendErr == nil {
+				fields = fields[i : i+1]
+				break
+			}
+		}
+		if appendErr != nil {
+			return MediaRef{}, fmt.Errorf("append segment %d failed: %w", index, appendErr)
+		}
+	}
+
+	finalResp, err := withRetry(ctx, retrySafe, func(ctx context.Context) (xdk.JSON, error) {
+		return p.client.Media.FinalizeUpload(ctx, xdk.Params{
+			"id": mediaID,
+		})
+	})
+	if err != nil {
+		return MediaRef{}, err
+	}
+	if err := p.waitForMediaProcessing(ctx, mediaID, finalResp); err != nil {
+		return MediaRef{}, err
+	}
+
+	finalRef := extractMediaRef(finalResp)
+	if finalRef.ID == "" {
+		finalRef.ID = mediaID
+	}
+	if finalRef.MediaKey == "" {
+		finalRef.MediaKey = initRef.MediaKey
+	}
+	if finalRef.ExpiresAt == nil {
+		finalRef.ExpiresAt = initRef.ExpiresAt
+	}
+	return finalRef, nil
+}
+
+// uploadMediaV1 streams the file into the multipart body through a pipe
+// rather than building the request in memory.
+func (p *Poster) uploadMediaV1(ctx context.Context, input mediaUploadInput) (MediaRef, error) {
+	const uploadURL = "https://upload.twitter.com/1.1/media/upload.json"
+	if p.client == nil || p.client.Auth == nil {
+		return MediaRef{}, errors.New("oauth1 auth is required for v1 media upload fallback")
+	}
+
+	f, err := input.Open()
+	if err != nil {
+		return MediaRef{}, err
+	}
+	defer f.Close()
+
+	body, bodyWriter := io.Pipe()
+	writer := multipart.NewWriter(bodyWriter)
+	go func() {
+		bodyWriter.CloseWithError(writeMultipartMedia(writer, f, input.ContentType))
+	}()
+	defer body.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, body)
+	if err != nil {
+		return MediaRef{}, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	authHeader, err := p.client.Auth.BuildRequestHeader(http.MethodPost, uploadURL, "")
+	if err != nil {
+		return MediaRef{}, err
+	}
+	req.Header.Set("Authorization", authHeader)
+
+	resp, err := p.client.HTTPClient.Do(req)
+	if err != nil {
+		return MediaRef{}, err
+	}
+	defer resp.Body.Close()
+
+	payload, err := io.ReadAll(io.LimitReader(resp.Body, 4*1024*1024))
+	if err != nil {
+		return MediaRef{}, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return MediaRef{}, &xdk.APIError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(payload))}
+	}
+
+	var obj any
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&obj); err != nil {
+		return MediaRef{}, fmt.Errorf("v1 media upload parse failed: %w", err)
+	}
+	ref := extractMediaRef(obj)
+	if ref.ID == "" {
+		return MediaRef{}, fmt.Errorf("v1 media upload returned no media id: %s", strings.TrimSpace(string(payload)))
+	}
+	return ref, nil
+}
+
+func writeMultipartMedia(writer *multipart.Writer, r io.Reader, contentType string) error {
+	part, err := writer.CreateFormFile("media", "upload")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return err
+	}
+	if strings.TrimSpace(contentType) != "" {
+		if err := writer.WriteField("media_type", contentType); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+func readMediaFile(input mediaUploadInput, limit int64) ([]byte, error) {
+	f, err := input.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, newMediaSizeError("media is larger than %d bytes", limit)
+	}
+	return data, nil
+}
+
+// appendMediaSegment retries transient failures: network errors, 429 and
+// 5xx. Other API errors are returned at once.
+func (p *Poster) appendMediaSegment(ctx context.Context, mediaID string, index int, field string, segment string) error {
+	_, err := withRetry(ctx, retrySafe, func(ctx context.Context) (xdk.JSON, error) {
+		return p.client.Media.AppendUpload(ctx, xdk.Params{
+			"id": mediaID,
+			"body": map[string]any{
+				"segment_index": index,
+				field:           segment,
+			},
+		})
+	})
+	return err
+}
+
+// waitForMediaProcessing polls STATUS while processing_info says the media
+// is still pending, honouring check_after_secs between polls.
+func (p *Poster) waitForMediaProcessing(ctx context.Context, mediaID string, resp xdk.JSON) error {
+	for {
+		info, ok := findProcessingInfo(resp)
+		if !ok {
+			return nil
+		}
+		state := stringify(info["state"])
+		switch state {
+		case "succeeded", "":
+			return nil
+		case "failed":
+			msg := "media processing failed"
+			if detail, ok := info["error"].(map[string]any); ok {
+				if m := stringify(detail["message"]); m != "" {
+					msg += ": " + m
+				}
+			}
+			return errors.New(msg)
+		}
+
+		reportJobState(ctx, jobProcessing)
+		wait := mediaStatusMinInterval
+		if secs, err := strconv.Atoi(stringify(info["check_after_secs"])); err == nil && secs > 0 {
+			wait = time.Duration(secs) * time.Second
+		}
+		wait = min(wait, mediaStatusMaxInterval)
+		if err := sleepContext(ctx, wait); err != nil {
+			return fmt.Errorf("media %s still %s: %w", mediaID, state, err)
+		}
+
+		var err error
+		resp, err = withRetry(ctx, retrySafe, func(ctx context.Context) (xdk.JSON, error) {
+			return p.client.Media.GetUploadStatus(ctx, xdk.Params{
+				"media_id": mediaID,
+				"command":  "STATUS",
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("media status check failed: %w", err)
+		}
+	}
+}
+
+func findProcessingInfo(payload any) (map[string]any, bool) {
+	switch v := payload.(type) {
+	case xdk.JSON:
+		return findProcessingInfo(map[string]any(v))
+	case map[string]any:
+		if info, ok := v["processing_info"].(map[string]any); ok {
+			return info, true
+		}
+		if data, ok := v["data"]; ok {
+			return findProcessingInfo(data)
+		}
+	}
+	return nil, false
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
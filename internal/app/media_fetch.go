@@ -0,0 +1,187 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	mediaFetchTimeout   = 2 * time.Minute
+	mediaFetchRedirects = 3
+)
+
+// blockedMediaNetworks lists the ranges refused on top of loopback, private,
+// link-local, multicast and unspecified addresses.
+var blockedMediaNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// mediaURL is a media item the server downloads itself.
+type mediaURL struct {
+	URL     string
+	AltText string
+}
+
+// mediaFetcher downloads media_urls. The address check runs when a
+// connection is dialed, so it also covers redirects and DNS answers that
+// change between lookups.
+type mediaFetcher struct {
+	allowed []netip.Prefix
+	client  *http.Client
+}
+
+func newMediaFetcher(allowNetworks []string) (*mediaFetcher, error) {
+	f := &mediaFetcher{}
+	for _, raw := range allowNetworks {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(raw)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(raw)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid media_url_allow_networks entry %q", raw)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		f.allowed = append(f.allowed, prefix.Masked())
+	}
+
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			return f.checkDialAddress(address)
+		},
+	}
+	f.client = &http.Client{
+		// No proxy, so the dial check sees the real destination.
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > mediaFetchRedirects {
+				return fmt.Errorf("more than %d redirects", mediaFetchRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+	return f, nil
+}
+
+func (f *mediaFetcher) checkDialAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	addr = addr.Unmap()
+	for _, prefix := range f.allowed {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return fmt.Errorf("address %s is not allowed", addr)
+	}
+	for _, prefix := range blockedMediaNetworks {
+		if prefix.Contains(addr) {
+			return fmt.Errorf("address %s is not allowed", addr)
+		}
+	}
+	return nil
+}
+
+// parseMediaURLs validates media_urls without fetching anything.
+func parseMediaURLs(raw []string, altTexts []string) ([]mediaURL, error) {
+	out := make([]mediaURL, 0, len(raw))
+	for i, item := range raw {
+		u, err := url.Parse(strings.TrimSpace(item))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("media_urls[%d] must be an http or https URL", i)
+		}
+		out = append(out, mediaURL{URL: u.String()})
+	}
+	alts, err := normalizeAltTexts(altTexts, len(out))
+	if err != nil {
+		return nil, err
+	}
+	for i, alt := range alts {
+		out[i].AltText = alt
+	}
+	return out, nil
+}
+
+// fetchAll downloads every URL into a spool file. On error the files already
+// written are removed.
+func (f *mediaFetcher) fetchAll(ctx context.Context, urls []mediaURL) ([]mediaUploadInput, error) {
+	if len(urls) > 0 && f == nil {
+		return nil, errors.New("media_urls are not supported by this server")
+	}
+	media := make([]mediaUploadInput, 0, len(urls))
+	for i, u := range urls {
+		input, err := f.fetch(ctx, u.URL)
+		if err != nil {
+			releaseMedia(media)
+			return nil, fmt.Errorf("media_urls[%d]: %w", i, err)
+		}
+		input.AltText = u.AltText
+		media = append(media, input)
+	}
+	return media, nil
+}
+
+func (f *mediaFetcher) fetch(ctx context.Context, rawURL string) (mediaUploadInput, error) {
+	ctx, cancel := context.WithTimeout(ctx, mediaFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return mediaUploadInput{}, err
+	}
+	req.Header.Set("User-Agent", "xpost")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return mediaUploadInput{}, fmt.Errorf("download failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return mediaUploadInput{}, fmt.Errorf("download failed: %s", resp.Status)
+	}
+
+	// Servers often mislabel files, so the header only serves to turn away
+	// pages and other non-media early. The type itself comes from the bytes.
+	contentType := normalizeContentType(resp.Header.Get("Content-Type"))
+	if contentType != "" && contentType != "application/octet-stream" && !allowedMediaTypes[contentType] {
+		return mediaUploadInput{}, fmt.Errorf("unsupported content type %q", contentType)
+	}
+	if allowedMediaTypes[contentType] && resp.ContentLength > maxSourceBytesFor(mediaCategoryFromType(contentType)) {
+		return mediaUploadInput{}, newMediaSizeError("file is too large (%d bytes)", resp.ContentLength)
+	}
+
+	return spoolMedia("download", resp.Body, "")
+}
@@ -0,0 +1,219 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+
+func newTestFetcher(t *testing.T, allow ...string) *mediaFetcher {
+	t.Helper()
+	f, err := newMediaFetcher(allow)
+	if err != nil {
+		t.Fatalf("newMediaFetcher: %v", err)
+	}
+	return f
+}
+
+// mediaServer serves a small PNG at /image.png and whatever the test adds.
+func mediaServer(t *testing.T, extra func(mux *http.ServeMux)) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/image.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(pngHeader)
+	})
+	if extra != nil {
+		extra(mux)
+	}
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func fetchOne(f *mediaFetcher, rawURL string) (mediaUploadInput, error) {
+	media, err := f.fetchAll(context.Background(), []mediaURL{{URL: rawURL}})
+	if err != nil {
+		return mediaUploadInput{}, err
+	}
+	return media[0], nil
+}
+
+func TestCheckDialAddress(t *testing.T) {
+	f := newTestFetcher(t)
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", true},
+		{"127.0.0.1:80", false},
+		{"127.8.9.10:80", false},
+		{"[::1]:80", false},
+		{"10.1.2.3:80", false},
+		{"172.16.0.1:80", false},
+		{"192.168.1.1:80", false},
+		{"169.254.169.254:80", false},
+		{"[fe80::1]:80", false},
+		{"[fc00::1]:80", false},
+		{"0.0.0.0:80", false},
+		{"100.64.0.1:80", false},
+		{"224.0.0.1:80", false},
+		{"[::ffff:10.0.0.1]:80", false},
+		{"[::ffff:127.0.0.1]:80", false},
+	}
+	for _, tt := range tests {
+		err := f.checkDialAddress(tt.address)
+		if tt.allowed && err != nil {
+			t.Errorf("checkDialAddress(%s) = %v, want allowed", tt.address, err)
+		}
+		if !tt.allowed && err == nil {
+			t.Errorf("checkDialAddress(%s) allowed, want rejected", tt.address)
+		}
+	}
+}
+
+func TestFetchRejectsPrivateAddresses(t *testing.T) {
+	srv := mediaServer(t, nil)
+	f := newTestFetcher(t)
+	for _, rawURL := range []string{
+		srv.URL + "/image.png",
+		"http://localhost:" + srv.URL[strings.LastIndex(srv.URL, ":")+1:] + "/image.png",
+		"http://10.0.0.1/image.png",
+		"http://192.168.0.1/image.png",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::1]:9/image.png",
+	} {
+		_, err := fetchOne(f, rawURL)
+		if err == nil || !strings.Contains(err.Error(), "is not allowed") {
+			t.Errorf("fetch %s: err = %v, want address not allowed", rawURL, err)
+		}
+	}
+}
+
+func TestFetchAllowNetworks(t *testing.T) {
+	srv := mediaServer(t, nil)
+	f := newTestFetcher(t, "127.0.0.1")
+	input, err := fetchOne(f, srv.URL+"/image.png")
+	if err != nil {
+		t.Fatalf("fetch from allowed network: %v", err)
+	}
+	defer releaseMedia([]mediaUploadInput{input})
+	if input.ContentType != "image/png" || input.Size != int64(len(pngHeader)) {
+		t.Errorf("got %s, %d bytes; want image/png, %d bytes", input.ContentType, input.Size, len(pngHeader))
+	}
+
+	if _, err := newMediaFetcher([]string{"not-a-network"}); err == nil {
+		t.Error("invalid media_url_allow_networks entry was accepted")
+	}
+}
+
+func TestFetchRedirectToPrivateAddress(t *testing.T) {
+	srv := mediaServer(t, func(mux *http.ServeMux) {
+		mux.HandleFunc("/to-loopback", func(w http.ResponseWriter, r *http.Request) {
+			// 127.0.0.2 is loopback but outside the allowed 127.0.0.1/32.
+			_, port, _ := net.SplitHostPort(r.Host)
+			http.Redirect(w, r, "http://127.0.0.2:"+port+"/image.png", http.StatusFound)
+		})
+		mux.HandleFunc("/to-metadata", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+		})
+		mux.HandleFunc("/to-file", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+		})
+	})
+	f := newTestFetcher(t, "127.0.0.1/32")
+	for path, want := range map[string]string{
+		"/to-loopback": "is not allowed",
+		"/to-metadata": "is not allowed",
+		"/to-file":     "unsupported scheme",
+	} {
+		_, err := fetchOne(f, srv.URL+path)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("fetch %s: err = %v, want %q", path, err, want)
+		}
+	}
+}
+
+func TestFetchContentType(t *testing.T) {
+	srv := mediaServer(t, func(mux *http.ServeMux) {
+		mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("<html></html>"))
+		})
+		mux.HandleFunc("/octet", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(pngHeader)
+		})
+		mux.HandleFunc("/mislabeled", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write(pngHeader)
+		})
+		mux.HandleFunc("/not-media", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte("#!/bin/sh\necho hi\n"))
+		})
+		mux.HandleFunc("/missing", http.NotFound)
+	})
+	f := newTestFetcher(t, "127.0.0.1")
+
+	if _, err := fetchOne(f, srv.URL+"/page"); err == nil || !strings.Contains(err.Error(), "unsupported content type") {
+		t.Errorf("text/html: err = %v, want unsupported content type", err)
+	}
+	if _, err := fetchOne(f, srv.URL+"/not-media"); err == nil || !strings.Contains(err.Error(), "not a supported media type") {
+		t.Errorf("script as octet-stream: err = %v, want unsupported media type", err)
+	}
+	if _, err := fetchOne(f, srv.URL+"/missing"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("404: err = %v, want download failed", err)
+	}
+	for _, path := range []string{"/octet", "/mislabeled"} {
+		input, err := fetchOne(f, srv.URL+path)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if input.ContentType != "image/png" {
+			t.Errorf("%s: content type %s, want image/png from the bytes", path, input.ContentType)
+		}
+		releaseMedia([]mediaUploadInput{input})
+	}
+}
+
+func TestFetchSizeCutoff(t *testing.T) {
+	oversized := maxImageSourceBytes + 1
+	srv := mediaServer(t, func(mux *http.ServeMux) {
+		mux.HandleFunc("/declared", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			w.Header().Set("Content-Length", strconv.Itoa(oversized))
+			w.Write(pngHeader)
+		})
+		mux.HandleFunc("/streamed", func(w http.ResponseWriter, r *http.Request) {
+			// No Content-Length: the limit has to be enforced while reading.
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(pngHeader)
+			w.(http.Flusher).Flush()
+			chunk := bytes.Repeat([]byte{0}, 1<<20)
+			for sent := len(pngHeader); sent < oversized; sent += len(chunk) {
+				if _, err := w.Write(chunk); err != nil {
+					return
+				}
+			}
+		})
+	})
+	f := newTestFetcher(t, "127.0.0.1")
+	for _, path := range []string{"/declared", "/streamed"} {
+		_, err := fetchOne(f, srv.URL+path)
+		var sizeErr *mediaSizeError
+		if !errors.As(err, &sizeErr) {
+			t.Errorf("%s: err = %v, want media size error", path, err)
+		}
+	}
+}
@@ -0,0 +1,445 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+)
+
+const (
+	// maxImageSourceBytes is how large an incoming JPEG or PNG may be;
+	// prepareMedia brings it under maxImageBytes before upload.
+	maxImageSourceBytes = 20 * 1024 * 1024
+	// maxImagePixels guards against images that are small on disk but
+	// decode to huge bitmaps. 20 megapixels is about 80 MB as RGBA, and the
+	// rotated and scaled copies add to that, so this keeps one request
+	// within what a small server can spare.
+	maxImagePixels      = 20 * 1000 * 1000
+	maxImageDimension   = 8192
+	defaultJPEGQuality  = 85
+	imageResizeAttempts = 6
+)
+
+// Names reported in MediaRef.Transforms.
+const (
+	transformMetadataStripped = "metadata_stripped"
+	transformRotated          = "rotated"
+	transformResized          = "resized"
+	transformRecompressed     = "recompressed"
+)
+
+// ImageConfig controls how JPEG and PNG images are prepared before upload.
+// By default oversized images are downscaled and re-encoded, and EXIF, GPS
+// and other metadata is stripped.
+type ImageConfig struct {
+	Disable      bool `json:"disable,omitempty"`
+	JPEGQuality  int  `json:"jpeg_quality,omitempty"`
+	MaxDimension int  `json:"max_dimension,omitempty"`
+	KeepMetadata bool `json:"keep_metadata,omitempty"`
+}
+
+// imageConfig returns the image settings, creating them for env overrides.
+func (c *Config) imageConfig() *ImageConfig {
+	if c.Images == nil {
+		c.Images = &ImageConfig{}
+	}
+	return c.Images
+}
+
+func (a *App) imageConfig() *ImageConfig {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.cfg.Images
+}
+
+func (c *ImageConfig) enabled() bool {
+	return c == nil || !c.Disable
+}
+
+func (c *ImageConfig) quality() int {
+	if c == nil || c.JPEGQuality <= 0 {
+		return defaultJPEGQuality
+	}
+	return min(c.JPEGQuality, 100)
+}
+
+func (c *ImageConfig) maxDimension() int {
+	if c == nil || c.MaxDimension <= 0 {
+		return maxImageDimension
+	}
+	return min(c.MaxDimension, maxImageDimension)
+}
+
+func (c *ImageConfig) keepMetadata() bool {
+	return c != nil && c.KeepMetadata
+}
+
+// prepareMedia runs between parsing and upload. Images that need changes are
+// replaced in place by processed spool files, then every item is checked
+// against X's size limits.
+func prepareMedia(inputs []mediaUploadInput, cfg *ImageConfig) error {
+	for i := range inputs {
+		if cfg.enabled() {
+			out, err := prepareImage(inputs[i], cfg)
+			if err != nil {
+				return fmt.Errorf("media %d: %w", i, err)
+			}
+			if out.Path != inputs[i].Path {
+				releaseMedia(inputs[i : i+1])
+				inputs[i] = out
+			}
+		}
+		if err := checkMediaSize(fmt.Sprintf("media %d", i), inputs[i].Size, inputs[i].category()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepareImage returns input unchanged unless it is a JPEG or PNG that is too
+// large, carries metadata to strip, or needs its EXIF orientation applied.
+// Metadata alone is removed without re-encoding.
+func prepareImage(input mediaUploadInput, cfg *ImageConfig) (mediaUploadInput, error) {
+	var format string
+	switch input.ContentType {
+	case "image/jpeg":
+		format = "jpeg"
+	case "image/png":
+		format = "png"
+	default:
+		return input, nil
+	}
+
+	data, err := readMediaFile(input, maxImageSourceBytes)
+	if err != nil {
+		return input, err
+	}
+	info, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return input, fmt.Errorf("cannot read image: %w", err)
+	}
+	if info.Width*info.Height > maxImagePixels {
+		return input, fmt.Errorf("image is %dx%d pixels, over the %d megapixel limit", info.Width, info.Height, maxImagePixels/1000/1000)
+	}
+
+	orientation := 1
+	if format == "jpeg" {
+		orientation = jpegOrientation(data)
+	}
+	maxDim := cfg.maxDimension()
+	oversized := len(data) > maxImageBytes || info.Width > maxDim || info.Height > maxDim
+	stripped, hadMetadata := stripImageMetadata(format, data)
+
+	var out []byte
+	var transforms []string
+	switch {
+	case oversized || (orientation != 1 && !cfg.keepMetadata()):
+		// Re-encoding drops all metadata, so the EXIF orientation has to be
+		// applied to the pixels.
+		out, transforms, err = reencodeImage(data, format, orientation, cfg)
+		if err != nil {
+			return input, err
+		}
+		if hadMetadata {
+			transforms = append(transforms, transformMetadataStripped)
+		}
+	case hadMetadata && !cfg.keepMetadata():
+		out, transforms = stripped, []string{transformMetadataStripped}
+	default:
+		return input, nil
+	}
+
+	result, err := spoolMedia("processed image", bytes.NewReader(out), input.ContentType)
+	if err != nil {
+		return input, err
+	}
+	result.AltText = input.AltText
+	result.Transforms = append(append([]string(nil), input.Transforms...), transforms...)
+	return result, nil
+}
+
+func reencodeImage(data []byte, format string, orientation int, cfg *ImageConfig) ([]byte, []string, error) {
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot decode image: %w", err)
+	}
+	img := toRGBA(decoded)
+
+	var transforms []string
+	if orientation != 1 {
+		img = orientImage(img, orientation)
+		transforms = append(transforms, transformRotated)
+	}
+
+	srcW, srcH := img.Bounds().Dx(), img.Bounds().Dy()
+	w, h := fitWithin(srcW, srcH, cfg.maxDimension())
+	for attempt := 1; ; attempt++ {
+		scaled := img
+		if w != srcW || h != srcH {
+			scaled = downscale(img, w, h)
+		}
+		out, err := encodeImage(scaled, format, cfg.quality())
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(out) <= maxImageBytes || attempt == imageResizeAttempts {
+			if w != srcW || h != srcH {
+				transforms = append(transforms, transformResized)
+			}
+			return out, append(transforms, transformRecompressed), nil
+		}
+		// Encoded size grows roughly with the pixel count; aim a bit lower
+		// than the ratio suggests.
+		f := math.Sqrt(float64(maxImageBytes)/float64(len(out))) * 0.9
+		w, h = max(1, int(float64(w)*f)), max(1, int(float64(h)*f))
+	}
+}
+
+func encodeImage(img image.Image, format string, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if format == "png" {
+		err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func toRGBA(src image.Image) *image.RGBA {
+	if img, ok := src.(*image.RGBA); ok {
+		return img
+	}
+	b := src.Bounds()
+	img := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(img, img.Bounds(), src, b.Min, draw.Src)
+	return img
+}
+
+func fitWithin(w, h, maxDim int) (int, int) {
+	if w <= maxDim && h <= maxDim {
+		return w, h
+	}
+	f := float64(maxDim) / float64(max(w, h))
+	return max(1, int(float64(w)*f)), max(1, int(float64(h)*f))
+}
+
+// orientImage applies an EXIF orientation (2-8) to the pixels.
+func orientImage(src *image.RGBA, orientation int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+			s := src.PixOffset(b.Min.X+x, b.Min.Y+y)
+			d := dst.PixOffset(dx, dy)
+			copy(dst.Pix[d:d+4], src.Pix[s:s+4])
+		}
+	}
+	return dst
+}
+
+// downscale shrinks src to w x h by averaging the source pixels that fall
+// into each destination pixel.
+func downscale(src *image.RGBA, w, h int) *image.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for dy := 0; dy < h; dy++ {
+		y0 := dy * sh / h
+		y1 := max((dy+1)*sh/h, y0+1)
+		for dx := 0; dx < w; dx++ {
+			x0 := dx * sw / w
+			x1 := max((dx+1)*sw/w, x0+1)
+			var sum [4]uint64
+			for y := y0; y < y1; y++ {
+				off := src.PixOffset(b.Min.X+x0, b.Min.Y+y)
+				for x := x0; x < x1; x++ {
+					sum[0] += uint64(src.Pix[off])
+					sum[1] += uint64(src.Pix[off+1])
+					sum[2] += uint64(src.Pix[off+2])
+					sum[3] += uint64(src.Pix[off+3])
+					off += 4
+				}
+			}
+			n := uint64((x1 - x0) * (y1 - y0))
+			d := dst.PixOffset(dx, dy)
+			for i := range sum {
+				dst.Pix[d+i] = uint8(sum[i] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// stripImageMetadata removes EXIF, XMP, IPTC and comments from a JPEG, or the
+// eXIf, text and time chunks from a PNG, leaving the image data as is. It
+// reports whether anything was removed.
+func stripImageMetadata(format string, data []byte) ([]byte, bool) {
+	if format == "png" {
+		return stripPNGMetadata(data)
+	}
+	return stripJPEGMetadata(data)
+}
+
+func stripJPEGMetadata(data []byte) ([]byte, bool) {
+	out := []byte{0xFF, 0xD8}
+	removed := false
+	scan := walkJPEG(data, func(marker byte, segment []byte) {
+		// Keep JFIF (APP0), ICC profiles (APP2) and Adobe color info (APP14).
+		isApp := marker >= 0xE0 && marker <= 0xEF
+		if (isApp && marker != 0xE0 && marker != 0xE2 && marker != 0xEE) || marker == 0xFE {
+			removed = true
+			return
+		}
+		out = append(out, segment...)
+	})
+	if scan < 0 || !removed {
+		return data, false
+	}
+	return append(out, data[scan:]...), true
+}
+
+// walkJPEG calls fn for every marker segment up to the scan data and returns
+// the offset of the start-of-scan marker, or -1 if the file is malformed.
+func walkJPEG(data []byte, fn func(marker byte, segment []byte)) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return -1
+	}
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return -1
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			i++
+			continue
+		case marker == 0xDA:
+			return i
+		case marker == 0xD8 || marker == 0xD9 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			return -1
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if n < 2 || i+2+n > len(data) {
+			return -1
+		}
+		fn(marker, data[i:i+2+n])
+		i += 2 + n
+	}
+	return -1
+}
+
+// jpegOrientation reads the EXIF orientation tag, defaulting to 1.
+func jpegOrientation(data []byte) int {
+	orientation := 1
+	walkJPEG(data, func(marker byte, segment []byte) {
+		payload := segment[4:]
+		if marker != 0xE1 || !bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			return
+		}
+		if o := exifOrientation(payload[6:]); o >= 1 && o <= 8 {
+			orientation = o
+		}
+	})
+	return orientation
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) || ifd < 8 {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+func stripPNGMetadata(data []byte) ([]byte, bool) {
+	const sigLen = 8
+	if len(data) < sigLen {
+		return data, false
+	}
+	out := append([]byte(nil), data[:sigLen]...)
+	removed := false
+	for i := sigLen; i < len(data); {
+		if i+8 > len(data) {
+			return data, false
+		}
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + n
+		if n < 0 || end > len(data) {
+			return data, false
+		}
+		if pngMetadataChunks[string(data[i+4:i+8])] {
+			removed = true
+		} else {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	if !removed {
+		return data, false
+	}
+	return out, true
+}
@@ -0,0 +1,193 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxJSONBodyBytes bounds JSON requests, whose base64 media has to be held
+	// in memory while decoding. Large files should be sent as multipart.
+	maxJSONBodyBytes = 64 * 1024 * 1024
+	// maxFormValueBytes bounds a single non-file multipart field.
+	maxFormValueBytes = 64 * 1024
+	sniffLen          = 512
+	// maxAltTextChars is X's limit for media alt text.
+	maxAltTextChars = 1000
+)
+
+// mediaUploadInput is one attachment. Its bytes stay in a file: a temp spool
+// file for API requests, or a file the caller owns such as a CLI argument or
+// a scheduled copy. Only spooled files are removed by releaseMedia.
+type mediaUploadInput struct {
+	Path        string
+	Size        int64
+	ContentType string
+	// Category is the X media category, which for GIFs depends on whether
+	// they are animated.
+	Category   string
+	AltText    string
+	Transforms []string
+	spooled    bool
+}
+
+func (m mediaUploadInput) Open() (*os.File, error) {
+	return os.Open(m.Path)
+}
+
+func (m mediaUploadInput) category() string {
+	if m.Category != "" {
+		return m.Category
+	}
+	return mediaCategoryFromType(m.ContentType)
+}
+
+func releaseMedia(inputs []mediaUploadInput) {
+	for _, input := range inputs {
+		if input.spooled {
+			_ = os.Remove(input.Path)
+		}
+	}
+}
+
+// setAltTexts attaches alt texts to media by position. An empty entry leaves
+// that item without alt text.
+func setAltTexts(media []mediaUploadInput, altTexts []string) error {
+	alts, err := normalizeAltTexts(altTexts, len(media))
+	if err != nil {
+		return err
+	}
+	for i, alt := range alts {
+		media[i].AltText = alt
+	}
+	return nil
+}
+
+func normalizeAltTexts(altTexts []string, mediaCount int) ([]string, error) {
+	if len(altTexts) > mediaCount {
+		return nil, fmt.Errorf("got %d alt texts for %d media items", len(altTexts), mediaCount)
+	}
+	out := make([]string, 0, len(altTexts))
+	for i, alt := range altTexts {
+		alt = strings.TrimSpace(alt)
+		if utf8.RuneCountInString(alt) > maxAltTextChars {
+			return nil, fmt.Errorf("alt text for media %d is longer than %d characters", i, maxAltTextChars)
+		}
+		out = append(out, alt)
+	}
+	return out, nil
+}
+
+// spoolMedia copies r into a temp file without buffering it in memory. The
+// content type comes from the magic bytes and a declared type has to agree
+// with it. The copy stops as soon as the category's size limit is exceeded.
+func spoolMedia(name string, r io.Reader, contentType string) (mediaUploadInput, error) {
+	head, err := readHead(r)
+	if err != nil {
+		return mediaUploadInput{}, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	n := len(head)
+	if n == 0 {
+		return mediaUploadInput{}, fmt.Errorf("%s is empty", name)
+	}
+	contentType, err = mediaTypeFromContent(name, head, contentType)
+	if err != nil {
+		return mediaUploadInput{}, err
+	}
+	limit := maxSourceBytesFor(mediaCategoryFromType(contentType))
+
+	f, err := os.CreateTemp("", "xpost-media-*")
+	if err != nil {
+		return mediaUploadInput{}, err
+	}
+	input := mediaUploadInput{Path: f.Name(), ContentType: contentType, spooled: true}
+	fail := func(err error) (mediaUploadInput, error) {
+		_ = f.Close()
+		_ = os.Remove(input.Path)
+		return mediaUploadInput{}, err
+	}
+
+	if _, err := f.Write(head); err != nil {
+		return fail(err)
+	}
+	copied, err := io.Copy(f, io.LimitReader(r, limit+1-int64(n)))
+	if err != nil {
+		return fail(fmt.Errorf("failed to read %s: %w", name, err))
+	}
+	input.Size = int64(n) + copied
+	if err := checkSourceSize(name, input.Size, mediaCategoryFromType(contentType)); err != nil {
+		return fail(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+	if input.Category, err = mediaCategoryFor(contentType, f); err != nil {
+		return fail(fmt.Errorf("%s: %w", name, err))
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(input.Path)
+		return mediaUploadInput{}, err
+	}
+	return input, nil
+}
+
+// mediaInputFromFile describes a file the caller owns, identifying its type
+// by the magic bytes instead of reading it whole.
+func mediaInputFromFile(path string) (mediaUploadInput, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return mediaUploadInput{}, fmt.Errorf("failed to read media file %q: %w", path, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return mediaUploadInput{}, fmt.Errorf("failed to read media file %q: %w", path, err)
+	}
+	head, err := readHead(f)
+	if err != nil {
+		return mediaUploadInput{}, fmt.Errorf("failed to read media file %q: %w", path, err)
+	}
+	if len(head) == 0 {
+		return mediaUploadInput{}, fmt.Errorf("media file %q is empty", path)
+	}
+
+	name := fmt.Sprintf("file %q", path)
+	contentType, err := mediaTypeFromContent(name, head, "")
+	if err != nil {
+		return mediaUploadInput{}, err
+	}
+	if err := checkSourceSize(name, info.Size(), mediaCategoryFromType(contentType)); err != nil {
+		return mediaUploadInput{}, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return mediaUploadInput{}, fmt.Errorf("failed to read media file %q: %w", path, err)
+	}
+	category, err := mediaCategoryFor(contentType, f)
+	if err != nil {
+		return mediaUploadInput{}, fmt.Errorf("%s: %w", name, err)
+	}
+	return mediaUploadInput{Path: path, Size: info.Size(), ContentType: contentType, Category: category}, nil
+}
+
+// readHead reads up to sniffLen bytes. Unlike io.ReadFull it only treats a
+// plain io.EOF as the end of input, so a truncated base64 stream still fails.
+func readHead(r io.Reader) ([]byte, error) {
+	head := make([]byte, sniffLen)
+	n := 0
+	for n < len(head) {
+		m, err := r.Read(head[n:])
+		n += m
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return head[:n], nil
+}
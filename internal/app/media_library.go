@@ -0,0 +1,288 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultMediaLifetime applies when X does not say how long an upload
+	// stays usable.
+	defaultMediaLifetime = 24 * time.Hour
+	// mediaReuseMargin keeps a cached ID from being handed out just before X
+	// forgets it.
+	mediaReuseMargin = 15 * time.Minute
+)
+
+type libraryMedia struct {
+	SHA256      string    `json:"sha256"`
+	Account     string    `json:"account"`
+	ID          string    `json:"id"`
+	MediaKey    string    `json:"media_key,omitempty"`
+	ContentType string    `json:"content_type"`
+	AltText     string    `json:"alt_text,omitempty"`
+	Size        int64     `json:"size"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type mediaLibraryFile struct {
+	Media []libraryMedia `json:"media"`
+}
+
+// mediaLibrary remembers uploads in media.json next to the config file, keyed
+// by account, the SHA-256 of the bytes sent to X and the alt text, so
+// identical files are uploaded once per validity window. Alt text is part of
+// the key because X stores it on the media ID, which every post reusing the
+// ID shares. A nil library uploads every time.
+type mediaLibrary struct {
+	file *jsonStore
+}
+
+func newMediaLibrary(dir string) *mediaLibrary {
+	return &mediaLibrary{file: newJSONStore(filepath.Join(dir, "media.json"))}
+}
+
+func (l *mediaLibrary) lookup(account, sum, altText string, now time.Time) (libraryMedia, bool) {
+	var doc mediaLibraryFile
+	if err := l.file.load(&doc); err != nil {
+		log.Printf("warning: failed to read media library: %v", err)
+		return libraryMedia{}, false
+	}
+	for _, item := range doc.Media {
+		if item.Account == account && item.SHA256 == sum && item.AltText == altText && now.Add(mediaReuseMargin).Before(item.ExpiresAt) {
+			return item, true
+		}
+	}
+	return libraryMedia{}, false
+}
+
+func (l *mediaLibrary) byID(id string) (libraryMedia, bool) {
+	if l == nil {
+		return libraryMedia{}, false
+	}
+	var doc mediaLibraryFile
+	if err := l.file.load(&doc); err != nil {
+		return libraryMedia{}, false
+	}
+	for _, item := range doc.Media {
+		if item.ID == id {
+			return item, true
+		}
+	}
+	return libraryMedia{}, false
+}
+
+// record stores entry, replacing an older upload of the same bytes and alt
+// text and dropping entries that have expired.
+func (l *mediaLibrary) record(entry libraryMedia) error {
+	var doc mediaLibraryFile
+	return l.file.update(&doc, func() error {
+		kept := doc.Media[:0]
+		for _, item := range doc.Media {
+			if item.ExpiresAt.Before(entry.UploadedAt) {
+				continue
+			}
+			if item.Account == entry.Account && item.SHA256 == entry.SHA256 && item.AltText == entry.AltText {
+				continue
+			}
+			kept = append(kept, item)
+		}
+		doc.Media = append(kept, entry)
+		return nil
+	})
+}
+
+// upload returns a live cached ID for the same account, bytes and alt text,
+// or uploads input with its alt text and records the result.
+func (l *mediaLibrary) upload(ctx context.Context, poster *Poster, input mediaUploadInput) (MediaRef, error) {
+	if l == nil {
+		return uploadWithAltText(ctx, poster, input)
+	}
+	sum, err := fileSHA256(input)
+	if err != nil {
+		return MediaRef{}, err
+	}
+	now := time.Now().UTC()
+	if item, ok := l.lookup(poster.account, sum, input.AltText, now); ok {
+		expires := item.ExpiresAt
+		return MediaRef{ID: item.ID, MediaKey: item.MediaKey, AltText: item.AltText, SHA256: sum, ExpiresAt: &expires, Reused: true}, nil
+	}
+
+	ref, err := uploadWithAltText(ctx, poster, input)
+	if err != nil {
+		return MediaRef{}, err
+	}
+	ref.SHA256 = sum
+	if ref.ExpiresAt == nil {
+		expires := now.Add(defaultMediaLifetime)
+		ref.ExpiresAt = &expires
+	}
+	if ref.ID != "" {
+		err := l.record(libraryMedia{
+			SHA256:      sum,
+			Account:     poster.account,
+			ID:          ref.ID,
+			MediaKey:    ref.MediaKey,
+			ContentType: input.ContentType,
+			AltText:     input.AltText,
+			Size:        input.Size,
+			UploadedAt:  now,
+			ExpiresAt:   *ref.ExpiresAt,
+		})
+		if err != nil {
+			log.Printf("warning: failed to record media %s in library: %v", ref.ID, err)
+		}
+	}
+	return ref, nil
+}
+
+// checkMediaIDsUsable rejects media_ids the library knows will have expired
+// by the time a scheduled post goes out.
+func (l *mediaLibrary) checkMediaIDsUsable(ids []string, at time.Time) error {
+	for i, id := range ids {
+		if item, ok := l.byID(id); ok && item.ExpiresAt.Before(at) {
+			return fmt.Errorf("media_ids[%d] expires at %s, before the post is published", i, item.ExpiresAt.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+func fileSHA256(input mediaUploadInput) (string, error) {
+	f, err := input.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// parseMediaIDs validates media IDs given to attach existing uploads.
+func parseMediaIDs(raw []string) ([]string, error) {
+	ids := make([]string, 0, len(raw))
+	for _, v := range raw {
+		ids = append(ids, splitCSV(v)...)
+	}
+	for i, id := range ids {
+		if strings.Trim(id, "0123456789") != "" {
+			return nil, fmt.Errorf("media_ids[%d] is not a valid media id", i)
+		}
+	}
+	return ids, nil
+}
+
+func mediaRefsFromIDs(ids []string) []MediaRef {
+	refs := make([]MediaRef, 0, len(ids))
+	for _, id := range ids {
+		refs = append(refs, MediaRef{ID: id})
+	}
+	return refs
+}
+
+type uploadMediaJSONRequest struct {
+	MediaBase64      string `json:"media_base64"`
+	MediaContentType string `json:"media_content_type"`
+	MediaURL         string `json:"media_url"`
+	AltText          string `json:"alt_text"`
+	Account          string `json:"account"`
+}
+
+func (a *App) handleUploadMedia(c *gin.Context) {
+	media, account, err := a.parseUploadMediaRequest(c)
+	if err != nil {
+		c.JSON(errorResponse(err, http.StatusBadRequest, codeValidationFailed))
+		return
+	}
+	defer func() { releaseMedia(media) }()
+
+	if err := prepareMedia(media, a.imageConfig()); err != nil {
+		c.JSON(errorResponse(err, http.StatusBadRequest, codeValidationFailed))
+		return
+	}
+
+	poster, ok := a.posterForRequest(c, account)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), uploadTimeout(media))
+	defer cancel()
+
+	uploaded, err := uploadMediaInputs(ctx, poster, a.library, media)
+	a.persistOAuth2Token(poster)
+	if err != nil {
+		c.JSON(errorResponse(err, http.StatusBadGateway, codeXError))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":        true,
+		"account":   poster.account,
+		"auth_mode": poster.authMode,
+		"media":     uploaded[0],
+	})
+}
+
+// parseUploadMediaRequest reads exactly one file from a multipart "media"
+// field, or from media_base64 or media_url in a JSON body.
+func (a *App) parseUploadMediaRequest(c *gin.Context) ([]mediaUploadInput, string, error) {
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		values, media, err := readMultipartForm(c)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(media) != 1 {
+			releaseMedia(media)
+			return nil, "", errors.New("exactly one media file is required")
+		}
+		if err := setAltTexts(media, values["alt_text"]); err != nil {
+			releaseMedia(media)
+			return nil, "", err
+		}
+		return media, requestAccount(c, values.Get("account")), nil
+	}
+
+	var body uploadMediaJSONRequest
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxJSONBodyBytes)
+	if err := c.ShouldBindJSON(&body); err != nil {
+		return nil, "", err
+	}
+	account := requestAccount(c, body.Account)
+	hasBase64 := strings.TrimSpace(body.MediaBase64) != ""
+	hasURL := strings.TrimSpace(body.MediaURL) != ""
+	switch {
+	case hasBase64 && hasURL:
+		return nil, "", errors.New("media_base64 and media_url cannot be combined")
+	case hasURL:
+		urls, err := parseMediaURLs([]string{body.MediaURL}, []string{body.AltText})
+		if err != nil {
+			return nil, "", err
+		}
+		media, err := a.fetcher.fetchAll(c.Request.Context(), urls)
+		return media, account, err
+	case hasBase64:
+		var contentTypes []string
+		if body.MediaContentType != "" {
+			contentTypes = []string{body.MediaContentType}
+		}
+		media, err := decodeBase64Media([]string{body.MediaBase64}, contentTypes, []string{body.AltText})
+		return media, account, err
+	default:
+		return nil, "", errors.New("media_base64 or media_url is required")
+	}
+}
@@ -0,0 +1,45 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleCommandRejectsExpiringMediaIDs(t *testing.T) {
+	t.Setenv("XPOST_API_TOKEN", "t")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	t.Setenv("XPOST_CONFIG", path)
+	if err := saveConfig(path, &Config{Server: ServerConfig{Addr: defaultServerAddr}}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	lib := newMediaLibrary(dir)
+	for id, expires := range map[string]time.Time{"111": now.Add(time.Hour), "222": now.Add(48 * time.Hour)} {
+		err := lib.record(libraryMedia{SHA256: id, Account: defaultAccountName, ID: id, UploadedAt: now, ExpiresAt: expires})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	publishAt := now.Add(2 * time.Hour).Format(time.RFC3339)
+	err := runTweetCommand([]string{"-text", "later", "-media-id", "111", "-at", publishAt})
+	if err == nil || !strings.Contains(err.Error(), "before the post is published") {
+		t.Fatalf("media ID expiring before --at: err = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "scheduled.json")); !os.IsNotExist(err) {
+		t.Errorf("the post was scheduled anyway: %v", err)
+	}
+
+	if err := runTweetCommand([]string{"-text", "later", "-media-id", "222", "-at", publishAt}); err != nil {
+		t.Fatalf("media ID still valid at --at: %v", err)
+	}
+	posts, err := newScheduleStore(dir).list()
+	if err != nil || len(posts) != 1 {
+		t.Errorf("scheduled %d posts, %v; want 1", len(posts), err)
+	}
+}
@@ -0,0 +1,224 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"slices"
+	"strings"
+)
+
+// allowedMediaTypes are the formats X accepts for posts. Media is identified
+// by its magic bytes; a client-supplied type only has to agree with them.
+var allowedMediaTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"video/mp4":       true,
+	"video/quicktime": true,
+}
+
+var contentTypeAliases = map[string]string{
+	"image/jpg":   "image/jpeg",
+	"image/pjpeg": "image/jpeg",
+	"video/mov":   "video/quicktime",
+}
+
+// quickTimeAtoms are top-level atoms that old QuickTime files start with
+// instead of an ftyp box.
+var quickTimeAtoms = []string{"moov", "mdat", "wide", "free", "skip"}
+
+// sniffMediaType identifies an allowed format from the first bytes of a file,
+// or returns "".
+func sniffMediaType(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("\xFF\xD8\xFF")):
+		return "image/jpeg"
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return "image/gif"
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WEBP":
+		return "image/webp"
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		return sniffFtypBrand(head)
+	}
+	for _, atom := range quickTimeAtoms {
+		if len(head) >= 8 && string(head[4:8]) == atom {
+			return "video/quicktime"
+		}
+	}
+	return ""
+}
+
+// videoBrands are the ftyp brands of MP4 and QuickTime video. The same box
+// also starts HEIC, AVIF and M4A files, which X does not take as video.
+var videoBrands = map[string]bool{
+	"isom": true, "iso2": true, "iso4": true, "iso5": true, "iso6": true,
+	"mp41": true, "mp42": true, "avc1": true, "M4V ": true, "qt  ": true,
+}
+
+// imageBrands mark HEIF and AVIF images, even when a generic ISO brand is
+// listed next to them.
+var imageBrands = map[string]bool{
+	"heic": true, "heix": true, "heim": true, "heis": true, "hevc": true,
+	"hevx": true, "mif1": true, "msf1": true, "miaf": true, "avif": true,
+	"avis": true,
+}
+
+// audioBrands mark audio files. iTunes video lists M4A  as compatible too,
+// so only the major brand counts.
+var audioBrands = map[string]bool{"M4A ": true, "M4B ": true, "M4P ": true, "F4A ": true}
+
+// sniffFtypBrand reads the major brand of an ISO-BMFF ftyp box and the
+// compatible brands after it. The file is video only when the major brand,
+// or failing that a compatible one, is an MP4 or QuickTime brand, and
+// nothing marks it as an image or audio.
+func sniffFtypBrand(head []byte) string {
+	major := string(head[8:12])
+	end := min(int(binary.BigEndian.Uint32(head[:4])), len(head))
+	var compatible []string
+	for i := 16; i+4 <= end; i += 4 {
+		compatible = append(compatible, string(head[i:i+4]))
+	}
+	if audioBrands[major] || imageBrands[major] || slices.ContainsFunc(compatible, func(b string) bool { return imageBrands[b] }) {
+		return ""
+	}
+	if major == "qt  " {
+		return "video/quicktime"
+	}
+	if videoBrands[major] || slices.ContainsFunc(compatible, func(b string) bool { return videoBrands[b] }) {
+		return "video/mp4"
+	}
+	return ""
+}
+
+func normalizeContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(contentType))
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	if alias, ok := contentTypeAliases[mediaType]; ok {
+		return alias
+	}
+	return mediaType
+}
+
+// mediaTypeFromContent returns the sniffed type of head. A declared type
+// other than application/octet-stream must match it; MP4 and MOV count as
+// the same since clients mix them up freely.
+func mediaTypeFromContent(name string, head []byte, declared string) (string, error) {
+	detected := sniffMediaType(head)
+	if detected == "" {
+		return "", fmt.Errorf("%s is not a supported media type (JPEG, PNG, GIF, WebP, MP4 or MOV)", name)
+	}
+	declared = normalizeContentType(declared)
+	if declared == "" || declared == "application/octet-stream" || declared == detected {
+		return detected, nil
+	}
+	if strings.HasPrefix(declared, "video/") && strings.HasPrefix(detected, "video/") && allowedMediaTypes[declared] {
+		return detected, nil
+	}
+	return "", fmt.Errorf("%s is labeled %s but its content is %s", name, declared, detected)
+}
+
+// mediaCategoryFor picks the upload category from the content. Only animated
+// GIFs are tweet_gif; a single-frame GIF is an ordinary image.
+func mediaCategoryFor(contentType string, r io.Reader) (string, error) {
+	if contentType != "image/gif" {
+		return mediaCategoryFromType(contentType), nil
+	}
+	animated, err := gifIsAnimated(r)
+	if err != nil {
+		return "", err
+	}
+	if animated {
+		return mediaCategoryGIF, nil
+	}
+	return mediaCategoryImage, nil
+}
+
+var errMalformedGIF = errors.New("malformed GIF")
+
+// gifIsAnimated walks the GIF block structure until it finds a second frame
+// or the trailer, without decoding any pixels.
+func gifIsAnimated(r io.Reader) (bool, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return false, errMalformedGIF
+	}
+	if err := skipGIFColorTable(br, header[10]); err != nil {
+		return false, err
+	}
+
+	frames := 0
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return false, errMalformedGIF
+		}
+		switch b {
+		case 0x21: // extension: label, then data sub-blocks
+			if _, err := br.ReadByte(); err != nil {
+				return false, errMalformedGIF
+			}
+			if err := skipGIFSubBlocks(br); err != nil {
+				return false, err
+			}
+		case 0x2C: // image descriptor
+			frames++
+			if frames > 1 {
+				return true, nil
+			}
+			desc := make([]byte, 9)
+			if _, err := io.ReadFull(br, desc); err != nil {
+				return false, errMalformedGIF
+			}
+			if err := skipGIFColorTable(br, desc[8]); err != nil {
+				return false, err
+			}
+			if _, err := br.ReadByte(); err != nil { // LZW minimum code size
+				return false, errMalformedGIF
+			}
+			if err := skipGIFSubBlocks(br); err != nil {
+				return false, err
+			}
+		case 0x3B: // trailer
+			return false, nil
+		default:
+			return false, errMalformedGIF
+		}
+	}
+}
+
+func skipGIFColorTable(br *bufio.Reader, flags byte) error {
+	if flags&0x80 == 0 {
+		return nil
+	}
+	size := 3 * (1 << (int(flags&0x07) + 1))
+	if _, err := br.Discard(size); err != nil {
+		return errMalformedGIF
+	}
+	return nil
+}
+
+func skipGIFSubBlocks(br *bufio.Reader) error {
+	for {
+		n, err := br.ReadByte()
+		if err != nil {
+			return errMalformedGIF
+		}
+		if n == 0 {
+			return nil
+		}
+		if _, err := br.Discard(int(n)); err != nil {
+			return errMalformedGIF
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+func ftypBox(major string, compatible ...string) []byte {
+	size := 16 + 4*len(compatible)
+	box := make([]byte, 4, size)
+	binary.BigEndian.PutUint32(box, uint32(size))
+	box = append(box, "ftyp"+major+"\x00\x00\x02\x00"...)
+	for _, brand := range compatible {
+		box = append(box, brand...)
+	}
+	return box
+}
+
+func TestSniffMediaTypeFtyp(t *testing.T) {
+	tests := []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{"mp4 isom", ftypBox("isom", "isom", "iso2", "avc1", "mp41"), "video/mp4"},
+		{"mp4 mp42", ftypBox("mp42", "mp42", "isom"), "video/mp4"},
+		{"m4v", ftypBox("M4V ", "M4V ", "M4A ", "mp42", "isom"), "video/mp4"},
+		{"quicktime", ftypBox("qt  ", "qt  "), "video/quicktime"},
+		{"dash with iso compatible", ftypBox("dash", "iso6", "mp41"), "video/mp4"},
+		{"heic", ftypBox("heic", "mif1", "heic"), ""},
+		{"heif", ftypBox("mif1", "mif1", "heic"), ""},
+		{"avif", ftypBox("avif", "avif", "mif1", "miaf"), ""},
+		{"m4a", ftypBox("M4A ", "M4A ", "mp42", "isom"), ""},
+		{"unknown brand", ftypBox("abcd", "efgh"), ""},
+		{"truncated compatible list", ftypBox("mp42", "isom")[:18], "video/mp4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sniffMediaType(tt.head); got != tt.want {
+				t.Errorf("sniffMediaType() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMediaTypeFromContentRejectsHEIC(t *testing.T) {
+	if _, err := mediaTypeFromContent("photo.heic", ftypBox("heic", "mif1", "heic"), "video/mp4"); err == nil {
+		t.Fatal("HEIC labelled video/mp4 was accepted")
+	}
+}
+
+// GIF building blocks for a 1x1 image with a two-entry global color table.
+const (
+	gifHeader     = "GIF89a\x01\x00\x01\x00\x80\x00\x00" + "\x00\x00\x00\xff\xff\xff"
+	gifFrame      = "\x2c\x00\x00\x00\x00\x01\x00\x01\x00\x00" + "\x02\x02\x4c\x01\x00"
+	gifControl    = "\x21\xf9\x04\x00\x0a\x00\x00\x00"
+	gifLoop       = "\x21\xff\x0bNETSCAPE2.0\x03\x01\x00\x00\x00"
+	gifComment    = "\x21\xfe\x05hello\x00"
+	gifTrailer    = "\x3b"
+	gifLocalFrame = "\x2c\x00\x00\x00\x00\x01\x00\x01\x00\x80" + "\x00\x00\x00\xff\xff\xff" + "\x02\x02\x4c\x01\x00"
+)
+
+func encodedGIF(t *testing.T, frames int) []byte {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 4, 4), palette))
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGIFIsAnimated(t *testing.T) {
+	animated := gifHeader + gifLoop + gifControl + gifFrame + gifControl + gifFrame + gifTrailer
+	tests := []struct {
+		name     string
+		data     string
+		animated bool
+		wantErr  bool
+	}{
+		{name: "static", data: gifHeader + gifFrame + gifTrailer},
+		{name: "static with local palette", data: gifHeader + gifLocalFrame + gifTrailer},
+		{name: "static encoded", data: string(encodedGIF(t, 1))},
+		{name: "animated", data: animated, animated: true},
+		{name: "animated local palettes", data: gifHeader + gifLocalFrame + gifLocalFrame + gifTrailer, animated: true},
+		{name: "animated encoded", data: string(encodedGIF(t, 3)), animated: true},
+		// A loop extension or frame delay alone does not make a GIF animated.
+		{name: "loop extension, one frame", data: gifHeader + gifLoop + gifControl + gifFrame + gifTrailer},
+		{name: "extensions after the frame", data: gifHeader + gifFrame + gifComment + gifControl + gifTrailer},
+		{name: "extensions only", data: gifHeader + gifLoop + gifComment + gifTrailer},
+		{name: "no blocks", data: gifHeader + gifTrailer},
+		// The second frame is proof enough even when the data ends there.
+		{name: "truncated after second descriptor", data: gifHeader + gifFrame + "\x2c", animated: true},
+		{name: "truncated header", data: gifHeader[:8], wantErr: true},
+		{name: "truncated color table", data: gifHeader[:15], wantErr: true},
+		{name: "truncated frame", data: gifHeader + gifFrame[:12], wantErr: true},
+		{name: "truncated extension", data: gifHeader + gifLoop[:9], wantErr: true},
+		{name: "missing trailer", data: gifHeader + gifFrame, wantErr: true},
+		{name: "unknown block", data: gifHeader + "\x00" + gifTrailer, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := gifIsAnimated(bytes.NewReader([]byte(tt.data)))
+			if tt.wantErr {
+				if !errors.Is(err, errMalformedGIF) {
+					t.Fatalf("gifIsAnimated() = %v, %v; want malformed GIF", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.animated {
+				t.Fatalf("gifIsAnimated() = %v, %v; want %v", got, err, tt.animated)
+			}
+			// The hand-built files must be real GIFs with the expected frames.
+			if decoded, err := gif.DecodeAll(bytes.NewReader([]byte(tt.data))); err == nil && (len(decoded.Image) > 1) != tt.animated {
+				t.Errorf("image/gif decodes %d frames", len(decoded.Image))
+			}
+		})
+	}
+}
+
+func TestMediaCategoryForGIF(t *testing.T) {
+	for data, want := range map[string]string{
+		gifHeader + gifLoop + gifFrame + gifTrailer:               mediaCategoryImage,
+		gifHeader + gifControl + gifFrame + gifFrame + gifTrailer: mediaCategoryGIF,
+	} {
+		got, err := mediaCategoryFor("image/gif", bytes.NewReader([]byte(data)))
+		if err != nil || got != want {
+			t.Errorf("mediaCategoryFor() = %q, %v; want %q", got, err, want)
+		}
+	}
+	if _, err := mediaCategoryFor("image/gif", bytes.NewReader([]byte(gifHeader))); err == nil {
+		t.Error("truncated GIF was accepted")
+	}
+}
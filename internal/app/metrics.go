@@ -0,0 +1,332 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The metrics below are kept in process and served in the Prometheus text
+// format. They are always collected; server.metrics only decides whether
+// /metrics is reachable.
+var (
+	metricRequests = newCounterVec("xpost_http_requests_total",
+		"HTTP requests served, by route and status.", "method", "route", "status")
+	metricRequestDuration = newHistogramVec("xpost_http_request_duration_seconds",
+		"Time to serve HTTP requests, by route and status.",
+		[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}, "method", "route", "status")
+	metricPostsCreated = newCounterVec("xpost_posts_created_total",
+		"Posts created on X, by account and auth mode.", "account", "auth_mode")
+	metricMediaUploads = newCounterVec("xpost_media_upload_attempts_total",
+		"Media upload attempts, by strategy (simple, chunked, v1) and result.", "strategy", "result")
+	metricXErrors = newCounterVec("xpost_x_api_errors_total",
+		"Error responses from the X API, by HTTP status and xpost error code.", "status", "code")
+	metricOAuth2Refreshes = newCounterVec("xpost_oauth2_refreshes_total",
+		"OAuth2 token refreshes, by account and result.", "account", "result")
+	metricQueueDepth = newGaugeFunc("xpost_queue_depth",
+		"Posts waiting in each queue: async jobs, the outbox and its dead letters, and scheduled posts.", "queue")
+)
+
+var metricsRegistry = []metricFamily{
+	metricRequests,
+	metricRequestDuration,
+	metricPostsCreated,
+	metricMediaUploads,
+	metricXErrors,
+	metricOAuth2Refreshes,
+	metricQueueDepth,
+}
+
+type metricFamily interface {
+	write(w io.Writer)
+}
+
+// labelKey joins label values with a byte that cannot appear in them
+// unescaped, so it can key a map.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func formatLabels(names []string, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabelValue(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabelValue(extra[i+1])+`"`)
+	}
+	b.WriteString(strings.Join(pairs, ","))
+	b.WriteByte('}')
+	return b.String()
+}
+
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sortedKeys returns the label keys of a family in a stable order, so
+// scrapes are easy to diff.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+func (c *counterVec) inc(values ...string) {
+	c.mu.Lock()
+	c.values[labelKey(values)]++
+	c.mu.Unlock()
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeHeader(w, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		values := strings.Split(key, "\xff")
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, values), formatFloat(c.values[key]))
+	}
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogram{}}
+}
+
+func (h *histogramVec) observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := labelKey(values)
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(w, h.name, h.help, "histogram")
+	for _, key := range sortedKeys(h.series) {
+		values := strings.Split(key, "\xff")
+		s := h.series[key]
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", formatFloat(upper)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, values), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values), s.count)
+	}
+}
+
+// gaugeFunc reads its values at scrape time, for state that already lives
+// somewhere else, like the length of a queue.
+type gaugeFunc struct {
+	name, help string
+	label      string
+
+	mu      sync.Mutex
+	collect func() map[string]float64
+}
+
+func newGaugeFunc(name, help, label string) *gaugeFunc {
+	return &gaugeFunc{name: name, help: help, label: label}
+}
+
+func (g *gaugeFunc) set(collect func() map[string]float64) {
+	g.mu.Lock()
+	g.collect = collect
+	g.mu.Unlock()
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	g.mu.Lock()
+	collect := g.collect
+	g.mu.Unlock()
+	writeHeader(w, g.name, g.help, "gauge")
+	if collect == nil {
+		return
+	}
+	values := collect()
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels([]string{g.label}, []string{key}), formatFloat(values[key]))
+	}
+}
+
+// registerQueueMetrics points xpost_queue_depth at the queues of a.
+func (a *App) registerQueueMetrics() {
+	metricQueueDepth.set(func() map[string]float64 {
+		depth := map[string]float64{}
+		if a.jobs != nil {
+			depth["jobs"] = float64(len(a.jobs.tasks))
+		}
+		if a.outbox != nil {
+			if doc, err := a.outbox.list(); err == nil {
+				pending := 0
+				for _, entry := range doc.Entries {
+					if entry.Status != outboxStatusSent {
+						pending++
+					}
+				}
+				depth["outbox"] = float64(pending)
+				depth["outbox_dead_letter"] = float64(len(doc.DeadLetter))
+			}
+		}
+		if a.schedule != nil {
+			if posts, err := a.schedule.list(); err == nil {
+				depth["scheduled"] = float64(len(posts))
+			}
+		}
+		return depth
+	})
+}
+
+// metricsMiddleware counts requests and their latency. Routes are the
+// registered patterns, like /v1/jobs/:id, so IDs don't blow up the label
+// set.
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method, status := c.Request.Method, strconv.Itoa(c.Writer.Status())
+		metricRequests.inc(method, route, status)
+		metricRequestDuration.observe(time.Since(start).Seconds(), method, route, status)
+	}
+}
+
+func handleMetrics(c *gin.Context) {
+	var buf bytes.Buffer
+	for _, family := range metricsRegistry {
+		family.write(&buf)
+	}
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
+}
+
+// runMetricsServer serves /metrics on its own address, without API token
+// auth, so it can be kept on an admin network.
+func runMetricsServer(addr string) {
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.GET("/metrics", handleMetrics)
+	log.Printf("metrics listening on %s", addr)
+	if err := router.Run(addr); err != nil {
+		log.Printf("metrics server stopped: %v", err)
+	}
+}
+
+// xMetricsTransport counts X error responses and OAuth2 token refreshes.
+type xMetricsTransport struct {
+	base    http.RoundTripper
+	account string
+}
+
+func (t xMetricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	// Poster clients never exchange codes, so every call to the token
+	// endpoint is a refresh.
+	refresh := req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/2/oauth2/token")
+	if refresh {
+		result := "success"
+		if err != nil || resp.StatusCode >= 300 {
+			result = "error"
+		}
+		metricOAuth2Refreshes.inc(t.account, result)
+	}
+	if err != nil || resp.StatusCode < 400 {
+		return resp, err
+	}
+
+	// The body is read here to find the error code and handed on intact.
+	body, readErr := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	var rest io.Reader = bytes.NewReader(body)
+	if readErr != nil {
+		rest = io.MultiReader(rest, errReader{readErr})
+	}
+	resp.Body = io.NopCloser(rest)
+	detail := parseXError(resp.StatusCode, string(body))
+	detail.refresh = refresh && resp.StatusCode < 500
+	_, code := xErrorStatus(detail)
+	metricXErrors.inc(strconv.Itoa(resp.StatusCode), code)
+	return resp, nil
+}
+
+// errReader replays a read error after the buffered part of a body.
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
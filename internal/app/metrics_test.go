@@ -0,0 +1,93 @@
+package app
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCounterLabelEscaping(t *testing.T) {
+	c := newCounterVec("test_total", "Test counter.", "path")
+	c.inc(`a"b\c` + "\nd")
+	c.inc(`a"b\c` + "\nd")
+
+	var buf bytes.Buffer
+	c.write(&buf)
+	want := "# HELP test_total Test counter.\n# TYPE test_total counter\n" +
+		`test_total{path="a\"b\\c\nd"} 2` + "\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	h := newHistogramVec("test_seconds", "Test histogram.", []float64{0.1, 1}, "route")
+	for _, v := range []float64{0.05, 0.5, 0.5, 7} {
+		h.observe(v, "/v1/tweets")
+	}
+
+	var buf bytes.Buffer
+	h.write(&buf)
+	for _, line := range []string{
+		`test_seconds_bucket{route="/v1/tweets",le="0.1"} 1`,
+		`test_seconds_bucket{route="/v1/tweets",le="1"} 3`,
+		// Observations above every bucket only show up in +Inf, which must
+		// match the count.
+		`test_seconds_bucket{route="/v1/tweets",le="+Inf"} 4`,
+		`test_seconds_sum{route="/v1/tweets"} 8.05`,
+		`test_seconds_count{route="/v1/tweets"} 4`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing %q in\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestMetricsMiddlewareRoutes(t *testing.T) {
+	// The metrics are process-wide, so look at how much they move.
+	requests := func(values ...string) float64 {
+		metricRequests.mu.Lock()
+		defer metricRequests.mu.Unlock()
+		return metricRequests.values[labelKey(values)]
+	}
+	observed := func(values ...string) uint64 {
+		metricRequestDuration.mu.Lock()
+		defer metricRequestDuration.mu.Unlock()
+		if s, ok := metricRequestDuration.series[labelKey(values)]; ok {
+			return s.count
+		}
+		return 0
+	}
+	matched := []string{http.MethodGet, "/test/jobs/:id", "202"}
+	unmatched := []string{http.MethodGet, "unmatched", "404"}
+	beforeMatched, beforeUnmatched := requests(matched...), requests(unmatched...)
+	beforeObserved := observed(matched...)
+
+	r := gin.New()
+	r.Use(metricsMiddleware())
+	r.GET("/test/jobs/:id", func(c *gin.Context) { c.Status(http.StatusAccepted) })
+	for _, path := range []string{"/test/jobs/abc", "/test/jobs/def", "/test/no/such/route"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if got := requests(matched...) - beforeMatched; got != 2 {
+		t.Errorf("%d requests counted for /test/jobs/:id, want 2", int(got))
+	}
+	if got := requests(unmatched...) - beforeUnmatched; got != 1 {
+		t.Errorf("%d requests counted as unmatched, want 1", int(got))
+	}
+	if got := observed(matched...) - beforeObserved; got != 2 {
+		t.Errorf("%d latencies observed for /test/jobs/:id with status 202, want 2", got)
+	}
+
+	var buf bytes.Buffer
+	metricRequests.write(&buf)
+	metricRequestDuration.write(&buf)
+	if out := buf.String(); strings.Contains(out, "abc") || strings.Contains(out, "/test/no/such/route") {
+		t.Errorf("request paths leaked into the labels:\n%s", out)
+	}
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const oauthCallbackTimeout = 5 * time.Minute
+
+type oauthCallbackResult struct {
+	url string
+	err error
+}
+
+// oauthCallbackListener is a short-lived HTTP server bound to the redirect
+// URI's host and port. It accepts the first callback that carries the
+// expected state and hands the full callback URL to FetchToken.
+type oauthCallbackListener struct {
+	server *http.Server
+	result chan oauthCallbackResult
+}
+
+func listenForOAuthCallback(redirectURI string, state string) (*oauthCallbackListener, error) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redirect URI %q: %w", redirectURI, err)
+	}
+	if u.Scheme != "http" {
+		return nil, fmt.Errorf("callback listener needs an http:// redirect URI, got %q", redirectURI)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+	callbackPath := u.Path
+	if callbackPath == "" {
+		callbackPath = "/"
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	l := &oauthCallbackListener{
+		result: make(chan oauthCallbackResult, 1),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != callbackPath {
+			http.NotFound(w, r)
+			return
+		}
+
+		query := r.URL.Query()
+		if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state)) != 1 {
+			// Ignore stray or forged callbacks, errors included, and keep
+			// waiting for the real one.
+			http.Error(w, "State mismatch.", http.StatusBadRequest)
+			return
+		}
+		if msg := query.Get("error"); msg != "" {
+			if desc := query.Get("error_description"); desc != "" {
+				msg += ": " + desc
+			}
+			http.Error(w, "Authorization failed. You can close this tab.", http.StatusBadRequest)
+			l.deliver(oauthCallbackResult{err: fmt.Errorf("authorization denied: %s", msg)})
+			return
+		}
+		if query.Get("code") == "" {
+			http.Error(w, "Missing authorization code.", http.StatusBadRequest)
+			return
+		}
+
+		callbackURL := *u
+		callbackURL.RawQuery = r.URL.RawQuery
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte("Authorization received. You can close this tab and return to the terminal.\n"))
+		l.deliver(oauthCallbackResult{url: callbackURL.String()})
+	})
+	l.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := l.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			l.deliver(oauthCallbackResult{err: fmt.Errorf("callback listener failed: %w", err)})
+		}
+	}()
+	return l, nil
+}
+
+func (l *oauthCallbackListener) deliver(res oauthCallbackResult) {
+	select {
+	case l.result <- res:
+	default:
+	}
+}
+
+func (l *oauthCallbackListener) Wait(ctx context.Context) (string, error) {
+	select {
+	case res := <-l.result:
+		return res.url, res.err
+	case <-ctx.Done():
+		return "", errors.New("timed out waiting for the authorization callback")
+	}
+}
+
+func (l *oauthCallbackListener) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_ = l.server.Shutdown(ctx)
+}
+
+func checkOAuthCallbackState(callbackURL string, state string) error {
+	u, err := url.Parse(strings.TrimSpace(callbackURL))
+	if err != nil {
+		return fmt.Errorf("invalid callback URL: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(u.Query().Get("state")), []byte(state)) != 1 {
+		return errors.New("callback state does not match the login request")
+	}
+	return nil
+}
@@ -0,0 +1,506 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	xdk "github.com/missuo/xdk-go"
+)
+
+const (
+	outboxPollInterval   = 5 * time.Second
+	outboxMaxAttempts    = 10
+	outboxRetryBaseDelay = 30 * time.Second
+	outboxRetryMaxDelay  = 30 * time.Minute
+	// outboxSentRetention is how long delivered entries stay listed.
+	outboxSentRetention = 24 * time.Hour
+)
+
+const (
+	outboxStatusPending = "pending"
+	outboxStatusSending = "sending"
+	outboxStatusSent    = "sent"
+	outboxStatusDead    = "dead"
+)
+
+var (
+	errOutboxNotFound = errors.New("outbox entry not found")
+	errOutboxSending  = errors.New("outbox entry is being sent")
+	errOutboxSent     = errors.New("outbox entry was already sent")
+)
+
+type outboxEntry struct {
+	ID        string       `json:"id"`
+	Status    string       `json:"status"`
+	CreatedAt time.Time    `json:"created_at"`
+	Tweet     tweetRequest `json:"tweet"`
+	// ThreadParts is the split text of an auto_thread request.
+	ThreadParts   []string         `json:"thread_parts,omitempty"`
+	Media         []scheduledMedia `json:"media,omitempty"`
+	Attempts      int              `json:"attempts,omitempty"`
+	NextAttemptAt *time.Time       `json:"next_attempt_at,omitempty"`
+	LastError     string           `json:"last_error,omitempty"`
+	// TweetIDs are the posts already created, in thread order, so a thread
+	// that failed part way resumes where it stopped.
+	TweetIDs []string   `json:"tweet_ids,omitempty"`
+	SentAt   *time.Time `json:"sent_at,omitempty"`
+	DeadAt   *time.Time `json:"dead_at,omitempty"`
+}
+
+type outboxFile struct {
+	Entries    []outboxEntry `json:"entries"`
+	DeadLetter []outboxEntry `json:"dead_letter"`
+}
+
+// outboxStore keeps accepted posts in outbox.json next to the config file
+// until they reach X. Entries that keep failing move to the dead-letter list,
+// where they wait for `xpost outbox retry` or `xpost outbox drop`.
+type outboxStore struct {
+	file     *jsonStore
+	mediaDir string
+	wake     chan struct{}
+}
+
+func newOutboxStore(dir string) *outboxStore {
+	return &outboxStore{
+		file:     newJSONStore(filepath.Join(dir, "outbox.json")),
+		mediaDir: filepath.Join(dir, "outbox"),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// notify makes the sender look at the outbox now instead of at its next tick.
+func (s *outboxStore) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *outboxStore) add(req tweetRequest) (outboxEntry, error) {
+	entry := outboxEntry{
+		ID:          newRecordID(),
+		Status:      outboxStatusPending,
+		CreatedAt:   time.Now().UTC(),
+		Tweet:       req,
+		ThreadParts: req.threadParts,
+	}
+
+	media, err := storeMedia(s.mediaDir, entry.ID, req.Media)
+	if err != nil {
+		return outboxEntry{}, fmt.Errorf("failed to store outbox media: %w", err)
+	}
+	entry.Media = media
+
+	var doc outboxFile
+	err = s.file.update(&doc, func() error {
+		doc.Entries = append(doc.Entries, entry)
+		return nil
+	})
+	if err != nil {
+		removeStoredMedia(s.mediaDir, entry.Media)
+		return outboxEntry{}, err
+	}
+	s.notify()
+	return entry, nil
+}
+
+func (s *outboxStore) list() (outboxFile, error) {
+	var doc outboxFile
+	if err := s.file.load(&doc); err != nil {
+		return outboxFile{}, err
+	}
+	if doc.Entries == nil {
+		doc.Entries = []outboxEntry{}
+	}
+	if doc.DeadLetter == nil {
+		doc.DeadLetter = []outboxEntry{}
+	}
+	return doc, nil
+}
+
+// claimDue marks every pending entry whose next attempt is due as sending and
+// returns them in the order they were accepted. Delivered entries past
+// outboxSentRetention are dropped on the way.
+func (s *outboxStore) claimDue(now time.Time) ([]outboxEntry, error) {
+	var due []outboxEntry
+	var doc outboxFile
+	err := s.file.update(&doc, func() error {
+		kept := doc.Entries[:0]
+		for _, entry := range doc.Entries {
+			if entry.Status == outboxStatusSent && entry.SentAt != nil && now.Sub(*entry.SentAt) > outboxSentRetention {
+				continue
+			}
+			if entry.Status == outboxStatusPending && (entry.NextAttemptAt == nil || !entry.NextAttemptAt.After(now)) {
+				entry.Status = outboxStatusSending
+				due = append(due, entry)
+			}
+			kept = append(kept, entry)
+		}
+		doc.Entries = kept
+		return nil
+	})
+	return due, err
+}
+
+func (s *outboxStore) sent(id string, tweetIDs []string) error {
+	var done outboxEntry
+	var doc outboxFile
+	err := s.file.update(&doc, func() error {
+		for i := range doc.Entries {
+			entry := &doc.Entries[i]
+			if entry.ID != id {
+				continue
+			}
+			now := time.Now().UTC()
+			done = *entry
+			entry.Status = outboxStatusSent
+			entry.TweetIDs = tweetIDs
+			entry.SentAt = &now
+			entry.NextAttemptAt = nil
+			entry.LastError = ""
+			entry.Media = nil
+			return nil
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	removeStoredMedia(s.mediaDir, done.Media)
+	return nil
+}
+
+// fail records a failed attempt. The entry is tried again with exponential
+// backoff while retry is set and attempts remain, and moves to the dead-letter
+// list otherwise. tweetIDs are the posts of a thread that did go out.
+func (s *outboxStore) fail(id string, tweetIDs []string, cause error, retry bool) error {
+	var doc outboxFile
+	return s.file.update(&doc, func() error {
+		for i := range doc.Entries {
+			entry := &doc.Entries[i]
+			if entry.ID != id {
+				continue
+			}
+			now := time.Now().UTC()
+			entry.Attempts++
+			entry.LastError = cause.Error()
+			entry.TweetIDs = tweetIDs
+			if retry && entry.Attempts < outboxMaxAttempts {
+				next := now.Add(outboxBackoff(entry.Attempts))
+				entry.Status = outboxStatusPending
+				entry.NextAttemptAt = &next
+				return nil
+			}
+			entry.Status = outboxStatusDead
+			entry.NextAttemptAt = nil
+			entry.DeadAt = &now
+			doc.DeadLetter = append(doc.DeadLetter, *entry)
+			doc.Entries = append(doc.Entries[:i], doc.Entries[i+1:]...)
+			return nil
+		}
+		return nil
+	})
+}
+
+func outboxBackoff(attempts int) time.Duration {
+	delay := outboxRetryBaseDelay
+	for i := 1; i < attempts && delay < outboxRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	return jitter(min(delay, outboxRetryMaxDelay))
+}
+
+// retry queues a pending or dead-letter entry for delivery right away, with
+// a fresh set of attempts.
+func (s *outboxStore) retry(id string) (outboxEntry, error) {
+	var queued outboxEntry
+	var doc outboxFile
+	err := s.file.update(&doc, func() error {
+		for i := range doc.DeadLetter {
+			if doc.DeadLetter[i].ID != id {
+				continue
+			}
+			queued = doc.DeadLetter[i]
+			doc.DeadLetter = append(doc.DeadLetter[:i], doc.DeadLetter[i+1:]...)
+			queued.Status = outboxStatusPending
+			queued.Attempts = 0
+			queued.NextAttemptAt = nil
+			queued.DeadAt = nil
+			doc.Entries = append(doc.Entries, queued)
+			return nil
+		}
+		for i := range doc.Entries {
+			entry := &doc.Entries[i]
+			if entry.ID != id {
+				continue
+			}
+			switch entry.Status {
+			case outboxStatusSending:
+				return errOutboxSending
+			case outboxStatusSent:
+				return errOutboxSent
+			}
+			entry.Attempts = 0
+			entry.NextAttemptAt = nil
+			queued = *entry
+			return nil
+		}
+		return errOutboxNotFound
+	})
+	if err != nil {
+		return outboxEntry{}, err
+	}
+	s.notify()
+	return queued, nil
+}
+
+// drop removes an entry that is not being sent, along with its media.
+func (s *outboxStore) drop(id string) error {
+	var removed outboxEntry
+	var doc outboxFile
+	err := s.file.update(&doc, func() error {
+		for i, entry := range doc.DeadLetter {
+			if entry.ID == id {
+				removed = entry
+				doc.DeadLetter = append(doc.DeadLetter[:i], doc.DeadLetter[i+1:]...)
+				return nil
+			}
+		}
+		for i, entry := range doc.Entries {
+			if entry.ID != id {
+				continue
+			}
+			if entry.Status == outboxStatusSending {
+				return errOutboxSending
+			}
+			removed = entry
+			doc.Entries = append(doc.Entries[:i], doc.Entries[i+1:]...)
+			return nil
+		}
+		return errOutboxNotFound
+	})
+	if err != nil {
+		return err
+	}
+	removeStoredMedia(s.mediaDir, removed.Media)
+	return nil
+}
+
+// recoverInterrupted moves entries left in the sending state by a crash to
+// the dead-letter list. They may or may not have reached X, so retrying them
+// blindly could double-post.
+func (s *outboxStore) recoverInterrupted() error {
+	var doc outboxFile
+	return s.file.update(&doc, func() error {
+		kept := doc.Entries[:0]
+		for _, entry := range doc.Entries {
+			if entry.Status != outboxStatusSending {
+				kept = append(kept, entry)
+				continue
+			}
+			now := time.Now().UTC()
+			entry.Status = outboxStatusDead
+			entry.LastError = "interrupted while sending; check the timeline before retrying"
+			entry.DeadAt = &now
+			doc.DeadLetter = append(doc.DeadLetter, entry)
+		}
+		doc.Entries = kept
+		return nil
+	})
+}
+
+func (a *App) runOutbox(ctx context.Context) {
+	if a.outbox == nil {
+		return
+	}
+	if err := a.outbox.recoverInterrupted(); err != nil {
+		log.Printf("warning: failed to recover outbox entries: %v", err)
+	}
+
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		a.deliverOutbox(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-a.outbox.wake:
+		}
+	}
+}
+
+func (a *App) deliverOutbox(ctx context.Context) {
+	due, err := a.outbox.claimDue(time.Now())
+	if err != nil {
+		log.Printf("warning: failed to read outbox: %v", err)
+		return
+	}
+	for _, entry := range due {
+		tweetIDs, retry, err := a.sendOutboxEntry(ctx, entry)
+		if err != nil {
+			log.Printf("outbox entry %s failed: %v", entry.ID, err)
+			if err := a.outbox.fail(entry.ID, tweetIDs, err, retry); err != nil {
+				log.Printf("warning: failed to update outbox entry %s: %v", entry.ID, err)
+			}
+			continue
+		}
+		log.Printf("outbox entry %s sent as tweet %s", entry.ID, tweetIDs[0])
+		if err := a.outbox.sent(entry.ID, tweetIDs); err != nil {
+			log.Printf("warning: failed to update outbox entry %s: %v", entry.ID, err)
+		}
+	}
+}
+
+// sendOutboxEntry publishes entry and returns the IDs of the posts that went
+// out, including those of earlier attempts. retry reports whether a failure
+// is worth another attempt.
+func (a *App) sendOutboxEntry(ctx context.Context, entry outboxEntry) (tweetIDs []string, retry bool, err error) {
+	tweetIDs = entry.TweetIDs
+	poster, err := a.getPoster(entry.Tweet.Account)
+	if err != nil {
+		return tweetIDs, true, err
+	}
+	media, err := loadStoredMedia(a.outbox.mediaDir, entry.Media)
+	if err != nil {
+		return tweetIDs, false, fmt.Errorf("failed to read outbox media: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, uploadTimeout(media))
+	defer cancel()
+	defer a.persistOAuth2Token(poster)
+
+	if len(entry.ThreadParts) > 0 {
+		req := entry.Tweet
+		req.Media = media
+		req.threadParts = entry.ThreadParts
+		done := len(tweetIDs)
+		items := autoThreadItems(req)[done:]
+		replyTo := req.ReplyToTweetID
+		if done > 0 {
+			replyTo = tweetIDs[done-1]
+		}
+		result, err := postThread(ctx, poster, a.library, items, replyTo)
+		tweetIDs = append(tweetIDs, result.TweetIDs...)
+		if err != nil {
+			// Only a threadError comes from creating a post; anything else
+			// failed during the media uploads.
+			var te *threadError
+			posting := errors.As(err, &te)
+			if posting {
+				te.Index += done
+			}
+			return tweetIDs, outboxRetryable(err, posting), outboxError(err, posting)
+		}
+		return tweetIDs, false, nil
+	}
+
+	uploaded, err := uploadMediaInputs(ctx, poster, a.library, media)
+	if err != nil {
+		return tweetIDs, outboxRetryable(err, false), err
+	}
+	uploaded = append(uploaded, mediaRefsFromIDs(entry.Tweet.MediaIDs)...)
+	resp, err := poster.CreateTweet(ctx, entry.Tweet.Text, uploaded, entry.Tweet.ReplyToTweetID, entry.Tweet.tweetOptions)
+	if err != nil {
+		return tweetIDs, outboxRetryable(err, true), outboxError(err, true)
+	}
+	id := tweetIDFromResponse(resp)
+	if id == "" {
+		return tweetIDs, false, errPostedWithoutID
+	}
+	return []string{id}, false, nil
+}
+
+// outboxRetryable reports whether a failed delivery may be tried again.
+// posting is set when the error came from creating a post: a 5xx or a
+// dropped connection there may hide a post that did go out.
+func outboxRetryable(err error, posting bool) bool {
+	var apiErr *xdk.APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.StatusCode == http.StatusTooManyRequests, apiErr.StatusCode == http.StatusUnauthorized:
+			return true
+		case apiErr.StatusCode >= 500:
+			return !posting
+		}
+		return false
+	}
+	return !posting || isDialError(err)
+}
+
+// outboxError points out failures that may have posted anyway.
+func outboxError(err error, posting bool) error {
+	if posting && !outboxRetryable(err, posting) && mayHavePosted(err) {
+		return fmt.Errorf("%w; it may have been posted, check the timeline before retrying", err)
+	}
+	return err
+}
+
+// handleOutboxTweet stores req in the outbox and answers 202. The post is
+// accepted even when X or the account's credentials are down right now.
+func (a *App) handleOutboxTweet(c *gin.Context, req tweetRequest) {
+	a.mu.RLock()
+	_, account, err := a.cfg.accountAuth(req.Account)
+	a.mu.RUnlock()
+	if err != nil {
+		c.JSON(errorResponse(err, http.StatusBadRequest, codeValidationFailed))
+		return
+	}
+	if !checkAccountAllowed(c, account) {
+		return
+	}
+	req.Account = account
+	if err := a.library.checkMediaIDsUsable(req.MediaIDs, time.Now()); err != nil {
+		c.JSON(errorResponse(err, http.StatusBadRequest, codeValidationFailed))
+		return
+	}
+
+	entry, err := a.outbox.add(req)
+	if err != nil {
+		c.JSON(errorResponse(err, http.StatusInternalServerError, codeInternal))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"ok":     true,
+		"outbox": entry,
+	})
+}
+
+func (a *App) handleListOutbox(c *gin.Context) {
+	if a.outbox == nil {
+		c.JSON(http.StatusNotImplemented, errorBody(codeNotSupported, "outbox is not enabled on this server"))
+		return
+	}
+
+	doc, err := a.outbox.list()
+	if err != nil {
+		c.JSON(errorResponse(err, http.StatusInternalServerError, codeInternal))
+		return
+	}
+	token := requestAPIToken(c)
+	visible := func(entries []outboxEntry) []outboxEntry {
+		out := make([]outboxEntry, 0, len(entries))
+		for _, entry := range entries {
+			if token.allowsAccount(entry.Tweet.Account) {
+				out = append(out, entry)
+			}
+		}
+		return out
+	}
+	entries, dead := visible(doc.Entries), visible(doc.DeadLetter)
+
+	c.JSON(http.StatusOK, gin.H{
+		"outbox":      entries,
+		"dead_letter": dead,
+		"count":       len(entries),
+		"dead_count":  len(dead),
+	})
+}
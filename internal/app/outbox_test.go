@@ -0,0 +1,303 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rateLimited answers 429 with a reset too far off for xpost to wait for, so
+// the call fails at once.
+func rateLimited(w http.ResponseWriter) {
+	w.Header().Set("retry-after", "3600")
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte(`{"title": "Too Many Requests", "detail": "slow down"}`))
+}
+
+func outboxApp(t *testing.T, dir string, x http.Handler) *App {
+	t.Helper()
+	return &App{
+		cfg:     &Config{},
+		posters: map[string]*Poster{defaultAccountName: stubPoster(t, x)},
+		outbox:  newOutboxStore(dir),
+	}
+}
+
+func outboxEntryByID(t *testing.T, store *outboxStore, id string) (entry outboxEntry, dead bool) {
+	t.Helper()
+	doc, err := store.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range doc.Entries {
+		if e.ID == id {
+			return e, false
+		}
+	}
+	for _, e := range doc.DeadLetter {
+		if e.ID == id {
+			return e, true
+		}
+	}
+	t.Fatalf("outbox entry %s not found", id)
+	return outboxEntry{}, false
+}
+
+func TestOutboxDeliver(t *testing.T) {
+	x := &stubX{respond: postedTweet}
+	a := outboxApp(t, t.TempDir(), x)
+	entry, err := a.outbox.add(tweetRequest{Text: "hello", ReplyToTweetID: "42", Account: defaultAccountName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.deliverOutbox(context.Background())
+
+	got, dead := outboxEntryByID(t, a.outbox, entry.ID)
+	if dead || got.Status != outboxStatusSent || strings.Join(got.TweetIDs, ",") != "100" || got.SentAt == nil {
+		t.Fatalf("entry after delivery: %+v", got)
+	}
+	if strings.Join(x.replyTo, ",") != "42" {
+		t.Errorf("posted as a reply to %v, want 42", x.replyTo)
+	}
+	a.deliverOutbox(context.Background())
+	if len(x.replyTo) != 1 {
+		t.Errorf("a sent entry was delivered again")
+	}
+
+	// Sent entries are listed for a while, then dropped.
+	if _, err := a.outbox.claimDue(got.SentAt.Add(outboxSentRetention + time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if doc, _ := a.outbox.list(); len(doc.Entries) != 0 {
+		t.Errorf("sent entry kept past its retention: %+v", doc.Entries)
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  outboxRetryBaseDelay,
+		2:  2 * outboxRetryBaseDelay,
+		3:  4 * outboxRetryBaseDelay,
+		6:  16 * time.Minute,
+		7:  outboxRetryMaxDelay,
+		20: outboxRetryMaxDelay,
+	} {
+		for range 20 {
+			if got := outboxBackoff(attempts); got < want/2 || got >= want {
+				t.Fatalf("outboxBackoff(%d) = %s, want in [%s, %s)", attempts, got, want/2, want)
+			}
+		}
+	}
+}
+
+func TestOutboxRetryThenDeadLetter(t *testing.T) {
+	store := newOutboxStore(t.TempDir())
+	entry, err := store.add(tweetRequest{Text: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for attempt := 1; attempt < outboxMaxAttempts; attempt++ {
+		claimed, _ := store.claimDue(now)
+		if len(claimed) != 1 {
+			t.Fatalf("attempt %d: claimed %d entries, want 1", attempt, len(claimed))
+		}
+		if err := store.fail(entry.ID, nil, context.DeadlineExceeded, true); err != nil {
+			t.Fatal(err)
+		}
+		got, dead := outboxEntryByID(t, store, entry.ID)
+		if dead || got.Status != outboxStatusPending || got.Attempts != attempt || got.NextAttemptAt == nil {
+			t.Fatalf("attempt %d: %+v, want a pending retry", attempt, got)
+		}
+		if claimed, _ := store.claimDue(time.Now()); len(claimed) != 0 {
+			t.Fatalf("attempt %d: retried before its backoff", attempt)
+		}
+		now = *got.NextAttemptAt
+	}
+	store.claimDue(now)
+	if err := store.fail(entry.ID, nil, context.DeadlineExceeded, true); err != nil {
+		t.Fatal(err)
+	}
+	got, dead := outboxEntryByID(t, store, entry.ID)
+	if !dead || got.Status != outboxStatusDead || got.DeadAt == nil || got.Attempts != outboxMaxAttempts {
+		t.Fatalf("after %d attempts: %+v, want it in the dead-letter list", outboxMaxAttempts, got)
+	}
+
+	// xpost outbox retry brings it back with a fresh set of attempts.
+	if _, err := store.retry(entry.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, dead = outboxEntryByID(t, store, entry.ID)
+	if dead || got.Status != outboxStatusPending || got.Attempts != 0 {
+		t.Fatalf("after retry: %+v", got)
+	}
+	if err := store.drop(entry.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.retry(entry.ID); err != errOutboxNotFound {
+		t.Errorf("retry after drop: %v, want %v", err, errOutboxNotFound)
+	}
+}
+
+func TestOutboxDeliverFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		respond  func(w http.ResponseWriter, call int)
+		wantDead bool
+		mayHave  bool
+	}{
+		{name: "rate limited", respond: func(w http.ResponseWriter, call int) { rateLimited(w) }},
+		{
+			name: "server error",
+			respond: func(w http.ResponseWriter, call int) {
+				w.WriteHeader(http.StatusBadGateway)
+				w.Write([]byte(`{"title": "Bad Gateway", "detail": "upstream"}`))
+			},
+			wantDead: true,
+			mayHave:  true,
+		},
+		{
+			name: "duplicate",
+			respond: func(w http.ResponseWriter, call int) {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"title": "Forbidden", "detail": "You are not allowed to create a Tweet with duplicate content."}`))
+			},
+			wantDead: true,
+		},
+		{
+			name:     "posted without id",
+			respond:  func(w http.ResponseWriter, call int) { w.Write([]byte(`{"data": {}}`)) },
+			wantDead: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x := &stubX{respond: tt.respond}
+			a := outboxApp(t, t.TempDir(), x)
+			entry, err := a.outbox.add(tweetRequest{Text: "hello", Account: defaultAccountName})
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.deliverOutbox(context.Background())
+
+			got, dead := outboxEntryByID(t, a.outbox, entry.ID)
+			if dead != tt.wantDead || got.Attempts != 1 || got.LastError == "" {
+				t.Errorf("entry %+v in dead-letter list %v, want %v", got, dead, tt.wantDead)
+			}
+			if !dead && (got.Status != outboxStatusPending || got.NextAttemptAt == nil) {
+				t.Errorf("entry %+v, want a pending retry", got)
+			}
+			if strings.Contains(got.LastError, "may have been posted") != tt.mayHave {
+				t.Errorf("last error %q, want may-have-posted warning %v", got.LastError, tt.mayHave)
+			}
+			if len(x.replyTo) != 1 {
+				t.Errorf("create post sent %d times, want once", len(x.replyTo))
+			}
+		})
+	}
+}
+
+func TestOutboxResumesThreadAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	x := &stubX{respond: func(w http.ResponseWriter, call int) {
+		if call == 1 {
+			rateLimited(w)
+			return
+		}
+		postedTweet(w, call)
+	}}
+	a := outboxApp(t, dir, x)
+	entry, err := a.outbox.add(tweetRequest{
+		ReplyToTweetID: "42",
+		Account:        defaultAccountName,
+		threadParts:    []string{"one", "two", "three"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.deliverOutbox(context.Background())
+
+	got, dead := outboxEntryByID(t, a.outbox, entry.ID)
+	if dead || got.Status != outboxStatusPending || strings.Join(got.TweetIDs, ",") != "100" {
+		t.Fatalf("after the first attempt: %+v, want a retry that remembers post 100", got)
+	}
+
+	// A new server process reads the same outbox and sends the rest once
+	// the retry is due.
+	a = outboxApp(t, dir, x)
+	if err := a.outbox.recoverInterrupted(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.outbox.retry(entry.ID); err != nil {
+		t.Fatal(err)
+	}
+	a.deliverOutbox(context.Background())
+
+	got, _ = outboxEntryByID(t, a.outbox, entry.ID)
+	if got.Status != outboxStatusSent || strings.Join(got.TweetIDs, ",") != "100,102,103" {
+		t.Fatalf("after the retry: %+v", got)
+	}
+	// "one" went out once; "two" replied to it, and "three" to "two".
+	if strings.Join(x.replyTo, ",") != "42,100,100,102" {
+		t.Errorf("posts replied to %v, want 42,100,100,102", x.replyTo)
+	}
+}
+
+func TestOutboxRecoverInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	store := newOutboxStore(dir)
+	sending, _ := store.add(tweetRequest{Text: "sending"})
+	store.claimDue(time.Now())
+	pending, _ := store.add(tweetRequest{Text: "pending"})
+
+	store = newOutboxStore(dir)
+	if err := store.recoverInterrupted(); err != nil {
+		t.Fatal(err)
+	}
+	got, dead := outboxEntryByID(t, store, sending.ID)
+	if !dead || !strings.Contains(got.LastError, "check the timeline") {
+		t.Errorf("interrupted entry: %+v, want it in the dead-letter list", got)
+	}
+	if got, dead := outboxEntryByID(t, store, pending.ID); dead || got.Status != outboxStatusPending {
+		t.Errorf("pending entry: %+v, want it left alone", got)
+	}
+}
+
+func TestOutboxRejectsAsync(t *testing.T) {
+	outbox := newOutboxStore(t.TempDir())
+	h := newRouter(&App{cfg: &Config{Security: SecurityConfig{envToken: "t"}}, outbox: outbox})
+
+	post := func(target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer t")
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	for _, tt := range []struct{ target, body string }{
+		{"/v1/tweets", `{"text": "hello", "async": true}`},
+		{"/v1/tweets?async=true", `{"text": "hello"}`},
+	} {
+		w := post(tt.target, tt.body)
+		var body struct {
+			Code string `json:"code"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != http.StatusBadRequest || body.Code != codeNotSupported {
+			t.Errorf("%s %s: %d %s, want 400 %s", tt.target, tt.body, w.Code, w.Body, codeNotSupported)
+		}
+	}
+	if doc, err := outbox.list(); err != nil || len(doc.Entries) != 0 {
+		t.Fatalf("outbox = %+v, %v; want it empty", doc.Entries, err)
+	}
+
+	if w := post("/v1/tweets", `{"text": "hello"}`); w.Code != http.StatusAccepted || !strings.Contains(w.Body.String(), `"outbox"`) {
+		t.Errorf("plain post: %d %s, want 202 with the outbox entry", w.Code, w.Body)
+	}
+}
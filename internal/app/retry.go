@@ -0,0 +1,260 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	xdk "github.com/missuo/xdk-go"
+)
+
+const (
+	xRetryAttempts  = 4
+	xRetryBaseDelay = 500 * time.Millisecond
+	xRetryMaxDelay  = 8 * time.Second
+	// maxRateLimitWait is the longest xpost sleeps for a rate limit to reset;
+	// beyond that the 429 goes back to the caller.
+	maxRateLimitWait = time.Minute
+)
+
+// retryPolicy says when a failed X call may be sent again.
+type retryPolicy int
+
+const (
+	// retrySafe calls can be repeated without side effects beyond a spare
+	// upload: reads, media uploads and metadata. They are retried on network
+	// errors, 429 and 5xx.
+	retrySafe retryPolicy = iota
+	// retryUnsent calls create something, like a post. They are only retried
+	// when X cannot have acted on them: on 429, or when the connection was
+	// never made.
+	retryUnsent
+)
+
+// rateLimitInfo is what X reported in its x-rate-limit-* and retry-after
+// headers.
+type rateLimitInfo struct {
+	Limit      string
+	Remaining  string
+	Reset      string
+	RetryAfter string
+}
+
+// wait returns how long X asked us to hold off, or 0 if it didn't say.
+func (r rateLimitInfo) wait(now time.Time) time.Duration {
+	if secs, err := strconv.Atoi(r.RetryAfter); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(r.RetryAfter); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	if reset, err := strconv.ParseInt(r.Reset, 10, 64); err == nil {
+		return max(time.Unix(reset, 0).Sub(now), 0)
+	}
+	return 0
+}
+
+// rateLimitRecorder collects the rate-limit headers of responses made under
+// a context. Recorders nest: a per-call recorder also reports to the
+// recorder of the API request it runs for.
+type rateLimitRecorder struct {
+	mu     sync.Mutex
+	info   rateLimitInfo
+	seen   bool
+	parent *rateLimitRecorder
+}
+
+type rateLimitRecorderKey struct{}
+
+func withRateLimitRecorder(ctx context.Context) (context.Context, *rateLimitRecorder) {
+	parent, _ := ctx.Value(rateLimitRecorderKey{}).(*rateLimitRecorder)
+	rec := &rateLimitRecorder{parent: parent}
+	return context.WithValue(ctx, rateLimitRecorderKey{}, rec), rec
+}
+
+func (r *rateLimitRecorder) record(h http.Header) {
+	info := rateLimitInfo{
+		Limit:      h.Get("x-rate-limit-limit"),
+		Remaining:  h.Get("x-rate-limit-remaining"),
+		Reset:      h.Get("x-rate-limit-reset"),
+		RetryAfter: h.Get("retry-after"),
+	}
+	for ; r != nil; r = r.parent {
+		r.mu.Lock()
+		r.info, r.seen = info, true
+		r.mu.Unlock()
+	}
+}
+
+func (r *rateLimitRecorder) last() (rateLimitInfo, bool) {
+	if r == nil {
+		return rateLimitInfo{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.info, r.seen
+}
+
+// rateLimitTransport hands the headers of every X response to the recorder
+// in the request context.
+type rateLimitTransport struct {
+	base http.RoundTripper
+}
+
+func (t rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		if rec, ok := req.Context().Value(rateLimitRecorderKey{}).(*rateLimitRecorder); ok {
+			rec.record(resp.Header)
+		}
+	}
+	return resp, err
+}
+
+func newXHTTPClient(account string) *http.Client {
+	return &http.Client{Transport: rateLimitTransport{base: xMetricsTransport{base: http.DefaultTransport, account: account}}}
+}
+
+// withRetry runs call until it succeeds, fails in a way policy does not
+// allow retrying, or xRetryAttempts is used up. Waits use exponential
+// backoff with jitter, or what X asked for on 429.
+func withRetry[T any](ctx context.Context, policy retryPolicy, call func(ctx context.Context) (T, error)) (T, error) {
+	delay := xRetryBaseDelay
+	for attempt := 1; ; attempt++ {
+		callCtx, rec := withRateLimitRecorder(ctx)
+		result, err := call(callCtx)
+		if err == nil || attempt == xRetryAttempts {
+			return result, err
+		}
+		info, _ := rec.last()
+		wait, ok := retryWait(err, policy, info, delay, time.Now())
+		if !ok {
+			return result, err
+		}
+		if deadline, has := ctx.Deadline(); has && time.Until(deadline) < wait {
+			return result, err
+		}
+		if sleepContext(ctx, wait) != nil {
+			return result, err
+		}
+		delay = min(delay*2, xRetryMaxDelay)
+	}
+}
+
+func retryWait(err error, policy retryPolicy, info rateLimitInfo, delay time.Duration, now time.Time) (time.Duration, bool) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return 0, false
+	}
+	var apiErr *xdk.APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.StatusCode == http.StatusTooManyRequests:
+			if wait := info.wait(now); wait > 0 {
+				return wait, wait <= maxRateLimitWait
+			}
+			return jitter(delay), true
+		case apiErr.StatusCode >= 500 && policy == retrySafe:
+			if wait := info.wait(now); wait > 0 {
+				return wait, wait <= maxRateLimitWait
+			}
+			return jitter(delay), true
+		}
+		return 0, false
+	}
+	if (policy == retrySafe && isNetworkError(err)) || isDialError(err) {
+		return jitter(delay), true
+	}
+	return 0, false
+}
+
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// jitter spreads retries over [d/2, d) so clients don't retry in lockstep.
+func jitter(d time.Duration) time.Duration {
+	return d/2 + rand.N(d/2)
+}
+
+// isDialError reports whether the request failed before reaching X.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+// mayHavePosted reports whether a failed create-post call could still have
+// created the post: X answered 5xx, or the request may have reached X before
+// the connection broke or the call timed out.
+func mayHavePosted(err error) bool {
+	var apiErr *xdk.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500
+	}
+	if _, ok := parseTokenRefreshError(err); ok {
+		return false
+	}
+	return !isDialError(err)
+}
+
+// rateLimitHeaders forwards the rate-limit state of the last X response of a
+// request to the API client, as X-Rate-Limit-* and Retry-After headers.
+func rateLimitHeaders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, rec := withRateLimitRecorder(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+		c.Writer = &rateLimitHeaderWriter{ResponseWriter: c.Writer, rec: rec}
+		c.Next()
+	}
+}
+
+// rateLimitHeaderWriter adds the headers just before the body goes out,
+// after the handler has talked to X.
+type rateLimitHeaderWriter struct {
+	gin.ResponseWriter
+	rec *rateLimitRecorder
+}
+
+func (w *rateLimitHeaderWriter) setHeaders() {
+	info, ok := w.rec.last()
+	if !ok || w.Written() {
+		return
+	}
+	h := w.Header()
+	for name, value := range map[string]string{
+		"X-Rate-Limit-Limit":     info.Limit,
+		"X-Rate-Limit-Remaining": info.Remaining,
+		"X-Rate-Limit-Reset":     info.Reset,
+		"Retry-After":            info.RetryAfter,
+	} {
+		if value != "" {
+			h.Set(name, value)
+		}
+	}
+}
+
+func (w *rateLimitHeaderWriter) WriteHeaderNow() {
+	w.setHeaders()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *rateLimitHeaderWriter) Write(b []byte) (int, error) {
+	w.setHeaders()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *rateLimitHeaderWriter) WriteString(s string) (int, error) {
+	w.setHeaders()
+	return w.ResponseWriter.WriteString(s)
+}
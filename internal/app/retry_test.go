@@ -0,0 +1,110 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	xdk "github.com/missuo/xdk-go"
+)
+
+func TestRetryWait(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	delay := 4 * time.Second
+	apiErr := func(status int) error { return &xdk.APIError{StatusCode: status} }
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	readErr := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+
+	const jittered = -1
+	tests := []struct {
+		name   string
+		err    error
+		policy retryPolicy
+		info   rateLimitInfo
+		want   time.Duration // jittered: somewhere in [delay/2, delay)
+		ok     bool
+	}{
+		{name: "429 retry-after", err: apiErr(429), policy: retryUnsent, info: rateLimitInfo{RetryAfter: "3"}, want: 3 * time.Second, ok: true},
+		{name: "429 reset", err: apiErr(429), policy: retryUnsent, info: rateLimitInfo{Reset: strconv.FormatInt(now.Add(20*time.Second).Unix(), 10)}, want: 20 * time.Second, ok: true},
+		{name: "429 reset too far", err: apiErr(429), policy: retrySafe, info: rateLimitInfo{RetryAfter: "120"}, want: 2 * time.Minute},
+		{name: "429 without headers", err: apiErr(429), policy: retryUnsent, want: jittered, ok: true},
+		{name: "5xx safe", err: apiErr(503), policy: retrySafe, want: jittered, ok: true},
+		{name: "5xx safe retry-after", err: apiErr(503), policy: retrySafe, info: rateLimitInfo{RetryAfter: "5"}, want: 5 * time.Second, ok: true},
+		{name: "5xx unsent", err: apiErr(503), policy: retryUnsent},
+		{name: "4xx", err: apiErr(403), policy: retrySafe},
+		{name: "dial error safe", err: dialErr, policy: retrySafe, want: jittered, ok: true},
+		{name: "dial error unsent", err: fmt.Errorf("post: %w", dialErr), policy: retryUnsent, want: jittered, ok: true},
+		{name: "dns error unsent", err: &net.DNSError{Err: "no such host", Name: "api.x.com"}, policy: retryUnsent, want: jittered, ok: true},
+		{name: "connection reset safe", err: readErr, policy: retrySafe, want: jittered, ok: true},
+		{name: "connection reset unsent", err: readErr, policy: retryUnsent},
+		{name: "unexpected eof unsent", err: io.ErrUnexpectedEOF, policy: retryUnsent},
+		{name: "canceled", err: context.Canceled, policy: retrySafe},
+		{name: "deadline", err: fmt.Errorf("upload: %w", context.DeadlineExceeded), policy: retrySafe},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := retryWait(tt.err, tt.policy, tt.info, delay, now)
+			if ok != tt.ok {
+				t.Fatalf("retry = %v, want %v (wait %s)", ok, tt.ok, got)
+			}
+			switch {
+			case tt.want == jittered:
+				if got < delay/2 || got >= delay {
+					t.Errorf("wait %s, want in [%s, %s)", got, delay/2, delay)
+				}
+			case got != tt.want:
+				t.Errorf("wait %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitInfoWait(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	tests := []struct {
+		info rateLimitInfo
+		want time.Duration
+	}{
+		{rateLimitInfo{}, 0},
+		{rateLimitInfo{RetryAfter: "7"}, 7 * time.Second},
+		{rateLimitInfo{RetryAfter: now.Add(time.Minute).UTC().Format(http.TimeFormat)}, time.Minute},
+		{rateLimitInfo{Reset: strconv.FormatInt(now.Add(30*time.Second).Unix(), 10)}, 30 * time.Second},
+		{rateLimitInfo{Reset: strconv.FormatInt(now.Add(-time.Minute).Unix(), 10)}, 0},
+		// retry-after wins over the window reset.
+		{rateLimitInfo{RetryAfter: "2", Reset: strconv.FormatInt(now.Add(time.Hour).Unix(), 10)}, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := tt.info.wait(now); got != tt.want {
+			t.Errorf("%+v: wait %s, want %s", tt.info, got, tt.want)
+		}
+	}
+}
+
+func TestMayHavePosted(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "5xx", err: &xdk.APIError{StatusCode: 502}, want: true},
+		{name: "rate limited", err: &xdk.APIError{StatusCode: 429}},
+		{name: "rejected", err: fmt.Errorf("create post: %w", &xdk.APIError{StatusCode: 403})},
+		{name: "dial error", err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}},
+		{name: "dns error", err: &net.DNSError{Err: "no such host", Name: "api.x.com"}},
+		{name: "token refresh", err: errors.New(`token refresh failed: status=400 body={"error": "invalid_grant"}`)},
+		{name: "connection reset", err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}, want: true},
+		{name: "timeout", err: context.DeadlineExceeded, want: true},
+		{name: "unexpected eof", err: io.ErrUnexpectedEOF, want: true},
+	}
+	for _, tt := range tests {
+		if got := mayHavePosted(tt.err); got != tt.want {
+			t.Errorf("%s: mayHavePosted = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
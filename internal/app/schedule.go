@@ -0,0 +1,463 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	scheduledPollInterval = 15 * time.Second
+	scheduledMaxAttempts  = 3
+	scheduledRetryDelay   = time.Minute
+)
+
+const (
+	scheduledStatusPending = "pending"
+	scheduledStatusSending = "sending"
+	scheduledStatusFailed  = "failed"
+)
+
+var (
+	errScheduledNotFound = errors.New("scheduled post not found")
+	errScheduledSending  = errors.New("scheduled post is being published")
+)
+
+type scheduledPost struct {
+	ID            string           `json:"id"`
+	Status        string           `json:"status"`
+	PublishAt     time.Time        `json:"publish_at"`
+	CreatedAt     time.Time        `json:"created_at"`
+	Tweet         tweetRequest     `json:"tweet"`
+	Media         []scheduledMedia `json:"media,omitempty"`
+	Attempts      int              `json:"attempts,omitempty"`
+	NextAttemptAt *time.Time       `json:"next_attempt_at,omitempty"`
+	LastError     string           `json:"last_error,omitempty"`
+}
+
+type scheduledMedia struct {
+	File        string   `json:"file"`
+	ContentType string   `json:"content_type"`
+	Category    string   `json:"category,omitempty"`
+	AltText     string   `json:"alt_text,omitempty"`
+	Transforms  []string `json:"transforms,omitempty"`
+}
+
+type scheduleFile struct {
+	Posts []scheduledPost `json:"posts"`
+}
+
+// scheduleStore keeps scheduled posts in scheduled.json next to the config
+// file. Media bytes live in a sibling directory, one file per attachment.
+type scheduleStore struct {
+	file     *jsonStore
+	mediaDir string
+}
+
+func newScheduleStore(dir string) *scheduleStore {
+	return &scheduleStore{
+		file:     newJSONStore(filepath.Join(dir, "scheduled.json")),
+		mediaDir: filepath.Join(dir, "scheduled"),
+	}
+}
+
+func (s *scheduleStore) add(req tweetRequest) (scheduledPost, error) {
+	post := scheduledPost{
+		ID:        newRecordID(),
+		Status:    scheduledStatusPending,
+		PublishAt: req.PublishAt.UTC(),
+		CreatedAt: time.Now().UTC(),
+		Tweet:     req,
+	}
+
+	media, err := storeMedia(s.mediaDir, post.ID, req.Media)
+	if err != nil {
+		return scheduledPost{}, fmt.Errorf("failed to store scheduled media: %w", err)
+	}
+	post.Media = media
+
+	var doc scheduleFile
+	err = s.file.update(&doc, func() error {
+		doc.Posts = append(doc.Posts, post)
+		return nil
+	})
+	if err != nil {
+		s.removeMedia(post)
+		return scheduledPost{}, err
+	}
+	return post, nil
+}
+
+func (s *scheduleStore) list() ([]scheduledPost, error) {
+	var doc scheduleFile
+	if err := s.file.load(&doc); err != nil {
+		return nil, err
+	}
+	if doc.Posts == nil {
+		return []scheduledPost{}, nil
+	}
+	return doc.Posts, nil
+}
+
+func (s *scheduleStore) cancel(id string) error {
+	var removed scheduledPost
+	var doc scheduleFile
+	err := s.file.update(&doc, func() error {
+		for i, post := range doc.Posts {
+			if post.ID != id {
+				continue
+			}
+			if post.Status == scheduledStatusSending {
+				return errScheduledSending
+			}
+			removed = post
+			doc.Posts = append(doc.Posts[:i], doc.Posts[i+1:]...)
+			return nil
+		}
+		return errScheduledNotFound
+	})
+	if err != nil {
+		return err
+	}
+	s.removeMedia(removed)
+	return nil
+}
+
+// claimDue marks every pending post whose time has come as sending and
+// returns them, so a post is never handed to two dispatch rounds.
+func (s *scheduleStore) claimDue(now time.Time) ([]scheduledPost, error) {
+	var due []scheduledPost
+	var doc scheduleFile
+	err := s.file.update(&doc, func() error {
+		for i := range doc.Posts {
+			post := &doc.Posts[i]
+			if post.Status != scheduledStatusPending {
+				continue
+			}
+			next := post.PublishAt
+			if post.NextAttemptAt != nil {
+				next = *post.NextAttemptAt
+			}
+			if next.After(now) {
+				continue
+			}
+			post.Status = scheduledStatusSending
+			due = append(due, *post)
+		}
+		return nil
+	})
+	return due, err
+}
+
+func (s *scheduleStore) complete(id string) error {
+	var done scheduledPost
+	var doc scheduleFile
+	err := s.file.update(&doc, func() error {
+		for i, post := range doc.Posts {
+			if post.ID == id {
+				done = post
+				doc.Posts = append(doc.Posts[:i], doc.Posts[i+1:]...)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.removeMedia(done)
+	return nil
+}
+
+// fail records a failed attempt. The post is tried again later while retry
+// is set and attempts remain, and fails for good otherwise.
+func (s *scheduleStore) fail(id string, cause error, retry bool) error {
+	var doc scheduleFile
+	return s.file.update(&doc, func() error {
+		for i := range doc.Posts {
+			post := &doc.Posts[i]
+			if post.ID != id {
+				continue
+			}
+			post.Attempts++
+			post.LastError = cause.Error()
+			if !retry || post.Attempts >= scheduledMaxAttempts {
+				post.Status = scheduledStatusFailed
+				post.NextAttemptAt = nil
+				return nil
+			}
+			next := time.Now().UTC().Add(scheduledRetryDelay)
+			post.Status = scheduledStatusPending
+			post.NextAttemptAt = &next
+			return nil
+		}
+		return nil
+	})
+}
+
+// recoverInterrupted fails posts left in the sending state by a crash. They
+// may or may not have reached X, so retrying them blindly could double-post.
+func (s *scheduleStore) recoverInterrupted() error {
+	var doc scheduleFile
+	return s.file.update(&doc, func() error {
+		for i := range doc.Posts {
+			post := &doc.Posts[i]
+			if post.Status == scheduledStatusSending {
+				post.Status = scheduledStatusFailed
+				post.LastError = "interrupted while publishing; check the timeline before rescheduling"
+			}
+		}
+		return nil
+	})
+}
+
+func (s *scheduleStore) loadMedia(post scheduledPost) ([]mediaUploadInput, error) {
+	media, err := loadStoredMedia(s.mediaDir, post.Media)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scheduled media: %w", err)
+	}
+	return media, nil
+}
+
+func (s *scheduleStore) removeMedia(post scheduledPost) {
+	removeStoredMedia(s.mediaDir, post.Media)
+}
+
+// storeMedia copies inputs into dir as id-0, id-1, ... so a post can be sent
+// after the request that carried the files is gone.
+func storeMedia(dir string, id string, inputs []mediaUploadInput) ([]scheduledMedia, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	var media []scheduledMedia
+	for i, input := range inputs {
+		name := id + "-" + strconv.Itoa(i)
+		if err := copyFileAtomic(filepath.Join(dir, name), input.Path); err != nil {
+			removeStoredMedia(dir, media)
+			return nil, err
+		}
+		media = append(media, scheduledMedia{File: name, ContentType: input.ContentType, Category: input.Category, AltText: input.AltText, Transforms: input.Transforms})
+	}
+	return media, nil
+}
+
+func loadStoredMedia(dir string, items []scheduledMedia) ([]mediaUploadInput, error) {
+	media := make([]mediaUploadInput, 0, len(items))
+	for _, item := range items {
+		path := filepath.Join(dir, item.File)
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		media = append(media, mediaUploadInput{Path: path, Size: info.Size(), ContentType: item.ContentType, Category: item.Category, AltText: item.AltText, Transforms: item.Transforms})
+	}
+	return media, nil
+}
+
+func removeStoredMedia(dir string, items []scheduledMedia) {
+	for _, item := range items {
+		_ = os.Remove(filepath.Join(dir, item.File))
+	}
+}
+
+func (a *App) runScheduler(ctx context.Context) {
+	if a.schedule == nil {
+		return
+	}
+	if err := a.schedule.recoverInterrupted(); err != nil {
+		log.Printf("warning: failed to recover scheduled posts: %v", err)
+	}
+
+	ticker := time.NewTicker(scheduledPollInterval)
+	defer ticker.Stop()
+	for {
+		a.dispatchScheduled(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *App) dispatchScheduled(ctx context.Context) {
+	due, err := a.schedule.claimDue(time.Now())
+	if err != nil {
+		log.Printf("warning: failed to read scheduled posts: %v", err)
+		return
+	}
+	for _, post := range due {
+		tweetID, retry, err := a.publishScheduled(ctx, post)
+		if err != nil {
+			log.Printf("scheduled post %s failed: %v", post.ID, err)
+			if err := a.schedule.fail(post.ID, err, retry); err != nil {
+				log.Printf("warning: failed to update scheduled post %s: %v", post.ID, err)
+			}
+			continue
+		}
+		log.Printf("scheduled post %s published as tweet %s", post.ID, tweetID)
+		if err := a.schedule.complete(post.ID); err != nil {
+			log.Printf("warning: failed to remove scheduled post %s: %v", post.ID, err)
+		}
+	}
+}
+
+// publishScheduled posts post and returns the new tweet ID. On failure,
+// retry says whether trying again is safe: failures before the post is
+// created always are, but once X may have created it the post must not be
+// sent twice.
+func (a *App) publishScheduled(ctx context.Context, post scheduledPost) (string, bool, error) {
+	poster, err := a.getPoster(post.Tweet.Account)
+	if err != nil {
+		return "", true, err
+	}
+	media, err := a.schedule.loadMedia(post)
+	if err != nil {
+		return "", true, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, uploadTimeout(media))
+	defer cancel()
+
+	uploaded, err := uploadMediaInputs(ctx, poster, a.library, media)
+	if err != nil {
+		return "", true, err
+	}
+	uploaded = append(uploaded, mediaRefsFromIDs(post.Tweet.MediaIDs)...)
+	resp, err := poster.CreateTweet(ctx, post.Tweet.Text, uploaded, post.Tweet.ReplyToTweetID, post.Tweet.tweetOptions)
+	if err != nil {
+		return "", outboxRetryable(err, true), outboxError(err, true)
+	}
+	a.persistOAuth2Token(poster)
+	return tweetIDFromResponse(resp), false, nil
+}
+
+func (a *App) handleScheduleTweet(c *gin.Context, req tweetRequest) {
+	if a.schedule == nil {
+		c.JSON(http.StatusNotImplemented, errorBody(codeNotSupported, "scheduled posts are only available in local server mode"))
+		return
+	}
+
+	a.mu.RLock()
+	_, account, err := a.cfg.accountAuth(req.Account)
+	a.mu.RUnlock()
+	if err != nil {
+		c.JSON(errorResponse(err, http.StatusBadRequest, codeValidationFailed))
+		return
+	}
+	if !checkAccountAllowed(c, account) {
+		return
+	}
+	req.Account = account
+	if err := a.library.checkMediaIDsUsable(req.MediaIDs, req.PublishAt); err != nil {
+		c.JSON(errorResponse(err, http.StatusBadRequest, codeValidationFailed))
+		return
+	}
+
+	post, err := a.schedule.add(req)
+	if err != nil {
+		c.JSON(errorResponse(err, http.StatusInternalServerError, codeInternal))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"ok":        true,
+		"scheduled": post,
+	})
+}
+
+func (a *App) handleListScheduled(c *gin.Context) {
+	if a.schedule == nil {
+		c.JSON(http.StatusNotImplemented, errorBody(codeNotSupported, "scheduled posts are only available in local server mode"))
+		return
+	}
+
+	posts, err := a.schedule.list()
+	if err != nil {
+		c.JSON(errorResponse(err, http.StatusInternalServerError, codeInternal))
+		return
+	}
+	token := requestAPIToken(c)
+	visible := make([]scheduledPost, 0, len(posts))
+	for _, post := range posts {
+		if token.allowsAccount(post.Tweet.Account) {
+			visible = append(visible, post)
+		}
+	}
+	posts = visible
+
+	c.JSON(http.StatusOK, gin.H{
+		"scheduled": posts,
+		"count":     len(posts),
+	})
+}
+
+func (a *App) handleCancelScheduled(c *gin.Context) {
+	if a.schedule == nil {
+		c.JSON(http.StatusNotImplemented, errorBody(codeNotSupported, "scheduled posts are only available in local server mode"))
+		return
+	}
+
+	id := strings.TrimSpace(c.Param("id"))
+	posts, err := a.schedule.list()
+	if err != nil {
+		c.JSON(errorResponse(err, http.StatusInternalServerError, codeInternal))
+		return
+	}
+	for _, post := range posts {
+		if post.ID == id && !checkAccountAllowed(c, post.Tweet.Account) {
+			return
+		}
+	}
+
+	if err := a.schedule.cancel(id); err != nil {
+		switch {
+		case errors.Is(err, errScheduledNotFound):
+			c.JSON(http.StatusNotFound, errorBody(codeNotFound, err.Error()))
+		case errors.Is(err, errScheduledSending):
+			c.JSON(http.StatusConflict, errorBody(codeConflict, err.Error()))
+		default:
+			c.JSON(errorResponse(err, http.StatusInternalServerError, codeInternal))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok": true,
+		"id": id,
+	})
+}
+
+// parsePublishAt accepts an RFC 3339 timestamp or unix seconds. An empty value
+// means "publish now".
+func parsePublishAt(raw string) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return time.Time{}, nil
+	}
+
+	var t time.Time
+	if n, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		t = time.Unix(n, 0)
+	} else {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid publish_at %q, use RFC 3339 or unix seconds", raw)
+		}
+		t = parsed
+	}
+
+	if !t.After(time.Now()) {
+		return time.Time{}, errors.New("publish_at must be in the future")
+	}
+	return t.UTC(), nil
+}
@@ -0,0 +1,205 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func scheduleAt(t *testing.T, store *scheduleStore, text string, at time.Time, media ...mediaUploadInput) scheduledPost {
+	t.Helper()
+	post, err := store.add(tweetRequest{Text: text, PublishAt: at, Media: media})
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	return post
+}
+
+func scheduledByID(t *testing.T, store *scheduleStore, id string) (scheduledPost, bool) {
+	t.Helper()
+	posts, err := store.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, post := range posts {
+		if post.ID == id {
+			return post, true
+		}
+	}
+	return scheduledPost{}, false
+}
+
+func TestScheduleClaimAndComplete(t *testing.T) {
+	dir := t.TempDir()
+	store := newScheduleStore(dir)
+	src := filepath.Join(dir, "upload.png")
+	if err := os.WriteFile(src, pngHeader, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	due := scheduleAt(t, store, "due", now.Add(-time.Second), mediaUploadInput{Path: src, ContentType: "image/png"})
+	later := scheduleAt(t, store, "later", now.Add(time.Hour))
+
+	stored := filepath.Join(store.mediaDir, due.Media[0].File)
+	if _, err := os.Stat(stored); err != nil {
+		t.Fatalf("media not stored: %v", err)
+	}
+
+	claimed, err := store.claimDue(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != due.ID || claimed[0].Status != scheduledStatusSending {
+		t.Fatalf("claimed %+v, want only the due post", claimed)
+	}
+	if again, _ := store.claimDue(now); len(again) != 0 {
+		t.Errorf("a post being sent was claimed twice: %+v", again)
+	}
+	if err := store.cancel(due.ID); err != errScheduledSending {
+		t.Errorf("cancel while sending: %v, want %v", err, errScheduledSending)
+	}
+
+	if err := store.complete(due.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := scheduledByID(t, store, due.ID); ok {
+		t.Error("completed post is still listed")
+	}
+	if _, err := os.Stat(stored); !os.IsNotExist(err) {
+		t.Errorf("media of a completed post left behind: %v", err)
+	}
+	if _, ok := scheduledByID(t, store, later.ID); !ok {
+		t.Error("the later post was dropped")
+	}
+
+	if err := store.cancel(later.ID); err != nil {
+		t.Errorf("cancel: %v", err)
+	}
+	if err := store.cancel(later.ID); err != errScheduledNotFound {
+		t.Errorf("second cancel: %v, want %v", err, errScheduledNotFound)
+	}
+}
+
+func TestScheduleFail(t *testing.T) {
+	store := newScheduleStore(t.TempDir())
+	now := time.Now()
+	retried := scheduleAt(t, store, "retried", now)
+	final := scheduleAt(t, store, "final", now)
+	store.claimDue(now)
+
+	if err := store.fail(final.ID, errPostedWithoutID, false); err != nil {
+		t.Fatal(err)
+	}
+	post, _ := scheduledByID(t, store, final.ID)
+	if post.Status != scheduledStatusFailed || post.Attempts != 1 || post.LastError != errPostedWithoutID.Error() {
+		t.Errorf("failure without retry: %+v", post)
+	}
+
+	for attempt := 1; attempt <= scheduledMaxAttempts; attempt++ {
+		if err := store.fail(retried.ID, errors.New("x is down"), true); err != nil {
+			t.Fatal(err)
+		}
+		post, _ = scheduledByID(t, store, retried.ID)
+		if attempt == scheduledMaxAttempts {
+			break
+		}
+		if post.Status != scheduledStatusPending || post.NextAttemptAt == nil {
+			t.Fatalf("attempt %d: %+v, want a retry", attempt, post)
+		}
+		if claimed, _ := store.claimDue(now); len(claimed) != 0 {
+			t.Fatalf("attempt %d: retried before its delay", attempt)
+		}
+		if claimed, _ := store.claimDue(post.NextAttemptAt.Add(time.Second)); len(claimed) != 1 {
+			t.Fatalf("attempt %d: not retried after its delay", attempt)
+		}
+	}
+	if post.Status != scheduledStatusFailed || post.Attempts != scheduledMaxAttempts || post.NextAttemptAt != nil {
+		t.Errorf("after %d attempts: %+v, want failed", scheduledMaxAttempts, post)
+	}
+}
+
+func TestScheduleRecoverInterrupted(t *testing.T) {
+	store := newScheduleStore(t.TempDir())
+	now := time.Now()
+	sending := scheduleAt(t, store, "sending", now)
+	store.claimDue(now)
+	pending := scheduleAt(t, store, "pending", now)
+
+	// A restarted server opens the same files.
+	store = newScheduleStore(filepath.Dir(store.mediaDir))
+	if err := store.recoverInterrupted(); err != nil {
+		t.Fatal(err)
+	}
+	post, _ := scheduledByID(t, store, sending.ID)
+	if post.Status != scheduledStatusFailed || !strings.Contains(post.LastError, "check the timeline") {
+		t.Errorf("interrupted post: %+v, want failed for review", post)
+	}
+	if post, _ := scheduledByID(t, store, pending.ID); post.Status != scheduledStatusPending {
+		t.Errorf("pending post: %+v, want it left alone", post)
+	}
+	if claimed, _ := store.claimDue(now); len(claimed) != 1 || claimed[0].ID != pending.ID {
+		t.Errorf("claimed %+v, want only the pending post", claimed)
+	}
+}
+
+func TestDispatchScheduledRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		wantStatus string
+		mayHave    bool
+	}{
+		{name: "posted", status: http.StatusCreated},
+		{name: "rate limited", status: http.StatusTooManyRequests, wantStatus: scheduledStatusPending},
+		{name: "server error", status: http.StatusServiceUnavailable, wantStatus: scheduledStatusFailed, mayHave: true},
+		{name: "rejected", status: http.StatusForbidden, wantStatus: scheduledStatusFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			calls := 0
+			x := &stubX{respond: func(w http.ResponseWriter, call int) {
+				mu.Lock()
+				calls++
+				mu.Unlock()
+				if tt.status == http.StatusCreated {
+					postedTweet(w, call)
+					return
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(`{"title": "error", "detail": "error"}`))
+			}}
+			store := newScheduleStore(t.TempDir())
+			a := &App{
+				cfg:      &Config{},
+				posters:  map[string]*Poster{defaultAccountName: stubPoster(t, x)},
+				schedule: store,
+			}
+			post := scheduleAt(t, store, "hello", time.Now().Add(-time.Second))
+			a.dispatchScheduled(context.Background())
+
+			got, listed := scheduledByID(t, store, post.ID)
+			if tt.wantStatus == "" {
+				if listed {
+					t.Fatalf("published post still listed: %+v", got)
+				}
+				return
+			}
+			if got.Status != tt.wantStatus {
+				t.Errorf("status %s, want %s (%s)", got.Status, tt.wantStatus, got.LastError)
+			}
+			if strings.Contains(got.LastError, "may have been posted") != tt.mayHave {
+				t.Errorf("last error %q, want may-have-posted warning %v", got.LastError, tt.mayHave)
+			}
+			if tt.status == http.StatusServiceUnavailable && calls != 1 {
+				t.Errorf("create post sent %d times after a 5xx, want once", calls)
+			}
+		})
+	}
+}
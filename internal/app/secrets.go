@@ -0,0 +1,305 @@
+package app
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const (
+	sealedSecretPrefix = "enc:v1:"
+	masterKeySize      = 32
+	keyringService     = "xpost"
+	keyringAccount     = "master-key"
+)
+
+var errNoMasterKey = errors.New("no master key: set XPOST_MASTER_KEY, secrets.key_file or secrets.keyring")
+
+// SecretsConfig turns on sealing of the X credentials in the config file.
+// The key comes from XPOST_MASTER_KEY, then KeyFile, then the OS keyring.
+type SecretsConfig struct {
+	Encrypted bool   `json:"encrypted,omitempty"`
+	KeyFile   string `json:"key_file,omitempty"`
+	Keyring   bool   `json:"keyring,omitempty"`
+}
+
+// secretFields lists the sealed credentials of a profile by JSON name. The
+// name is also the AES-GCM additional data, so a sealed value cannot be
+// moved to another field.
+func secretFields(auth *XAuthConfig) map[string]*string {
+	return map[string]*string{
+		"api_secret":           &auth.APISecret,
+		"access_token":         &auth.AccessToken,
+		"access_token_secret":  &auth.AccessTokenSecret,
+		"oauth2_client_secret": &auth.OAuth2ClientSecret,
+		"oauth2_access_token":  &auth.OAuth2AccessToken,
+		"oauth2_refresh_token": &auth.OAuth2RefreshToken,
+	}
+}
+
+func (c *Config) secretsEncrypted() bool {
+	return c.Secrets != nil && c.Secrets.Encrypted
+}
+
+// openSecrets decrypts every sealed credential in place. The master key is
+// only resolved when the file actually holds sealed values.
+func (c *Config) openSecrets() error {
+	for name, auth := range c.profiles() {
+		for field, value := range secretFields(auth) {
+			if !strings.HasPrefix(*value, sealedSecretPrefix) {
+				continue
+			}
+			if c.masterKey == nil {
+				key, err := resolveMasterKey(c.Secrets)
+				if err != nil {
+					return err
+				}
+				c.masterKey = key
+			}
+			plain, err := openSecret(c.masterKey, field, *value)
+			if err != nil {
+				return fmt.Errorf("account %s: %s: %w", name, field, err)
+			}
+			*value = plain
+		}
+	}
+	return nil
+}
+
+// sealedCopy returns the config as it should be written to disk. Without
+// encryption that is the config itself.
+func (c *Config) sealedCopy() (*Config, error) {
+	if !c.secretsEncrypted() {
+		return c, nil
+	}
+	if c.masterKey == nil {
+		key, err := resolveMasterKey(c.Secrets)
+		if err != nil {
+			return nil, err
+		}
+		c.masterKey = key
+	}
+
+	out := *c
+	if c.Accounts != nil {
+		out.Accounts = make(map[string]*XAuthConfig, len(c.Accounts))
+		for name, auth := range c.Accounts {
+			if auth == nil {
+				continue
+			}
+			copied := *auth
+			out.Accounts[name] = &copied
+		}
+	}
+	for _, auth := range out.profiles() {
+		for field, value := range secretFields(auth) {
+			if *value == "" || strings.HasPrefix(*value, sealedSecretPrefix) {
+				continue
+			}
+			sealed, err := sealSecret(c.masterKey, field, *value)
+			if err != nil {
+				return nil, err
+			}
+			*value = sealed
+		}
+	}
+	return &out, nil
+}
+
+func (c *Config) profiles() map[string]*XAuthConfig {
+	out := map[string]*XAuthConfig{defaultAccountName: &c.X}
+	for name, auth := range c.Accounts {
+		if auth != nil && name != defaultAccountName {
+			out[name] = auth
+		}
+	}
+	return out
+}
+
+func sealSecret(key []byte, field, plain string) (string, error) {
+	aead, err := newSecretsAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plain), []byte(field))
+	return sealedSecretPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func openSecret(key []byte, field, sealed string) (string, error) {
+	aead, err := newSecretsAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(sealed, sealedSecretPrefix))
+	if err != nil || len(raw) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+	plain, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], []byte(field))
+	if err != nil {
+		return "", errors.New("cannot decrypt value, wrong master key?")
+	}
+	return string(plain), nil
+}
+
+func newSecretsAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func resolveMasterKey(s *SecretsConfig) ([]byte, error) {
+	if v := strings.TrimSpace(os.Getenv("XPOST_MASTER_KEY")); v != "" {
+		key, err := parseMasterKey(v)
+		if err != nil {
+			return nil, fmt.Errorf("XPOST_MASTER_KEY: %w", err)
+		}
+		return key, nil
+	}
+	if s == nil {
+		return nil, errNoMasterKey
+	}
+	if path := strings.TrimSpace(s.KeyFile); path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read master key file: %w", err)
+		}
+		key, err := parseMasterKey(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("master key file %s: %w", path, err)
+		}
+		return key, nil
+	}
+	if s.Keyring {
+		raw, err := readKeyringKey()
+		if err != nil {
+			return nil, err
+		}
+		key, err := parseMasterKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("keyring master key: %w", err)
+		}
+		return key, nil
+	}
+	return nil, errNoMasterKey
+}
+
+// loadOrCreateMasterKey is used by `xpost config encrypt`. It behaves like
+// resolveMasterKey but creates a missing key file or keyring entry.
+func loadOrCreateMasterKey(s *SecretsConfig) ([]byte, string, error) {
+	if strings.TrimSpace(os.Getenv("XPOST_MASTER_KEY")) != "" {
+		key, err := resolveMasterKey(s)
+		return key, "XPOST_MASTER_KEY", err
+	}
+	if path := strings.TrimSpace(s.KeyFile); path != "" {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			key := newMasterKey()
+			if err := writeFileAtomic(path, []byte(encodeMasterKey(key)+"\n")); err != nil {
+				return nil, "", fmt.Errorf("failed to write master key file: %w", err)
+			}
+			return key, "new key file " + path, nil
+		}
+		key, err := resolveMasterKey(s)
+		return key, "key file " + path, err
+	}
+	if s.Keyring {
+		if raw, err := readKeyringKey(); err == nil {
+			key, err := parseMasterKey(raw)
+			return key, "keyring", err
+		}
+		key := newMasterKey()
+		if err := writeKeyringKey(encodeMasterKey(key)); err != nil {
+			return nil, "", err
+		}
+		return key, "new keyring entry", nil
+	}
+	return nil, "", errNoMasterKey
+}
+
+// parseMasterKey accepts 32 bytes encoded as base64 or hex.
+func parseMasterKey(raw string) ([]byte, error) {
+	raw = strings.TrimSpace(raw)
+	if len(raw) == hex.EncodedLen(masterKeySize) {
+		if key, err := hex.DecodeString(raw); err == nil {
+			return key, nil
+		}
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if key, err := enc.DecodeString(raw); err == nil && len(key) == masterKeySize {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("master key must be %d bytes, base64 or hex encoded", masterKeySize)
+}
+
+func newMasterKey() []byte {
+	key := make([]byte, masterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("crypto/rand.Read failed: %v", err))
+	}
+	return key
+}
+
+func encodeMasterKey(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// readKeyringKey and writeKeyringKey use the platform keyring CLI: the macOS
+// keychain through security(1), and the Secret Service through secret-tool.
+// The key is never passed as an argument, where other local users could
+// read it from the process list.
+func readKeyringKey() (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", keyringAccount, "-w")
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", keyringAccount)
+	default:
+		return "", fmt.Errorf("keyring is not supported on %s, use XPOST_MASTER_KEY or secrets.key_file", runtime.GOOS)
+	}
+	out, err := cmd.Output()
+	if err != nil || len(bytes.TrimSpace(out)) == 0 {
+		return "", fmt.Errorf("master key not found in keyring (service %s, account %s)", keyringService, keyringAccount)
+	}
+	return string(bytes.TrimSpace(out)), nil
+}
+
+func writeKeyringKey(key string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		// security -i reads the command from stdin, so the key stays out of
+		// argv. The key is base64 and needs no escaping inside the quotes.
+		cmd = exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %q -a %q -w %q\n", keyringService, keyringAccount, key))
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.Command("secret-tool", "store", "--label=xpost master key", "service", keyringService, "account", keyringAccount)
+		cmd.Stdin = strings.NewReader(key)
+	default:
+		return fmt.Errorf("keyring is not supported on %s, use XPOST_MASTER_KEY or secrets.key_file", runtime.GOOS)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to store master key in keyring: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	// security -i exits 0 even when the command inside it failed, so read
+	// the key back before the config depends on it.
+	if stored, err := readKeyringKey(); err != nil || stored != key {
+		return errors.New("failed to store master key in keyring: it could not be read back")
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package app
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testMasterKey(b byte) []byte {
+	key := make([]byte, masterKeySize)
+	for i := range key {
+		key[i] = b
+	}
+	return key
+}
+
+func TestSealOpenSecret(t *testing.T) {
+	key := testMasterKey(1)
+	for field := range secretFields(&XAuthConfig{}) {
+		sealed, err := sealSecret(key, field, "value of "+field)
+		if err != nil {
+			t.Fatalf("%s: seal: %v", field, err)
+		}
+		if !strings.HasPrefix(sealed, sealedSecretPrefix) || strings.Contains(sealed, "value of") {
+			t.Fatalf("%s: sealed value %q", field, sealed)
+		}
+		plain, err := openSecret(key, field, sealed)
+		if err != nil || plain != "value of "+field {
+			t.Errorf("%s: open = %q, %v", field, plain, err)
+		}
+
+		for other := range secretFields(&XAuthConfig{}) {
+			if other == field {
+				continue
+			}
+			if _, err := openSecret(key, other, sealed); err == nil {
+				t.Errorf("%s: value opened as %s", field, other)
+			}
+		}
+		if _, err := openSecret(testMasterKey(2), field, sealed); err == nil {
+			t.Errorf("%s: value opened with the wrong key", field)
+		}
+	}
+
+	again, _ := sealSecret(key, "access_token", "same")
+	once, _ := sealSecret(key, "access_token", "same")
+	if again == once {
+		t.Error("sealing twice gave the same ciphertext")
+	}
+	for _, bad := range []string{sealedSecretPrefix, sealedSecretPrefix + "!!!", sealedSecretPrefix + "AAAA"} {
+		if _, err := openSecret(key, "access_token", bad); err == nil {
+			t.Errorf("malformed value %q opened", bad)
+		}
+	}
+}
+
+// secretValues returns the credential fields of auth by JSON name.
+func secretValues(auth *XAuthConfig) map[string]string {
+	out := map[string]string{}
+	for field, value := range secretFields(auth) {
+		out[field] = *value
+	}
+	return out
+}
+
+func sameSecrets(a, b *XAuthConfig) bool {
+	return maps.Equal(secretValues(a), secretValues(b))
+}
+
+func testSecretsConfig() *Config {
+	return &Config{
+		Server:  ServerConfig{Addr: defaultServerAddr},
+		Secrets: &SecretsConfig{Encrypted: true},
+		X: XAuthConfig{
+			APIKey:            "api-key",
+			APISecret:         "api-secret",
+			AccessToken:       "access-token",
+			AccessTokenSecret: "access-token-secret",
+		},
+		Accounts: map[string]*XAuthConfig{
+			"brand": {
+				OAuth2ClientID:     "client-id",
+				OAuth2ClientSecret: "client-secret",
+				OAuth2AccessToken:  "oauth2-access",
+				OAuth2RefreshToken: "oauth2-refresh",
+			},
+		},
+		Security: SecurityConfig{APITokens: []APIToken{{ID: "t", TokenHash: "scrypt$1$1$1$AA$AA", Scopes: []string{scopeAll}}}},
+	}
+}
+
+func TestConfigSecretsRoundTrip(t *testing.T) {
+	t.Setenv("XPOST_MASTER_KEY", hex.EncodeToString(testMasterKey(3)))
+	t.Setenv("XPOST_API_TOKEN", "")
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	cfg := testSecretsConfig()
+	if err := saveConfig(path, cfg); err != nil {
+		t.Fatalf("saveConfig: %v", err)
+	}
+	if cfg.X.APISecret != "api-secret" || cfg.Accounts["brand"].OAuth2RefreshToken != "oauth2-refresh" {
+		t.Fatal("saveConfig sealed the in-memory config")
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plain := range []string{"api-secret", "access-token", "client-secret", "oauth2-access", "oauth2-refresh"} {
+		if strings.Contains(string(raw), `"`+plain+`"`) {
+			t.Errorf("%s written in plaintext", plain)
+		}
+	}
+	// Identifiers are not secret and stay readable.
+	for _, plain := range []string{"api-key", "client-id"} {
+		if !strings.Contains(string(raw), `"`+plain+`"`) {
+			t.Errorf("%s was sealed", plain)
+		}
+	}
+
+	loaded, _, err := loadOrInitConfig(path)
+	if err != nil {
+		t.Fatalf("loadOrInitConfig: %v", err)
+	}
+	want := testSecretsConfig()
+	for name, auth := range want.profiles() {
+		if got := loaded.profiles()[name]; got == nil || !sameSecrets(got, auth) {
+			t.Errorf("account %s: loaded %v, want %v", name, secretValues(got), secretValues(auth))
+		}
+	}
+	if loaded.X.APIKey != "api-key" || loaded.Accounts["brand"].OAuth2ClientID != "client-id" {
+		t.Error("identifiers changed on load")
+	}
+}
+
+func TestConfigSecretsRejectMovedValue(t *testing.T) {
+	t.Setenv("XPOST_MASTER_KEY", hex.EncodeToString(testMasterKey(4)))
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := saveConfig(path, testSecretsConfig()); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc map[string]any
+	raw, _ := os.ReadFile(path)
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+	x := doc["x"].(map[string]any)
+	x["access_token"], x["api_secret"] = x["api_secret"], x["access_token"]
+	raw, _ = json.Marshal(doc)
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := loadOrInitConfig(path); err == nil {
+		t.Fatal("config with swapped ciphertexts loaded")
+	}
+}
+
+func TestConfigSecretsWrongKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	t.Setenv("XPOST_MASTER_KEY", hex.EncodeToString(testMasterKey(5)))
+	if err := saveConfig(path, testSecretsConfig()); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XPOST_MASTER_KEY", hex.EncodeToString(testMasterKey(6)))
+	if _, _, err := loadOrInitConfig(path); err == nil || !strings.Contains(err.Error(), "wrong master key") {
+		t.Fatalf("load with the wrong key: err = %v", err)
+	}
+	t.Setenv("XPOST_MASTER_KEY", "")
+	if _, _, err := loadOrInitConfig(path); err == nil {
+		t.Fatal("sealed config loaded without a key")
+	}
+}
+
+func TestConfigSecretsPlaintextPassThrough(t *testing.T) {
+	// No key anywhere: plaintext values must not need one.
+	t.Setenv("XPOST_MASTER_KEY", "")
+	cfg := testSecretsConfig()
+	cfg.Secrets = nil
+	if err := cfg.openSecrets(); err != nil {
+		t.Fatalf("openSecrets on plaintext: %v", err)
+	}
+	if out, err := cfg.sealedCopy(); err != nil || out != cfg {
+		t.Fatalf("sealedCopy without encryption = %p, %v; want the config itself", out, err)
+	}
+	if !sameSecrets(&cfg.X, &testSecretsConfig().X) {
+		t.Error("plaintext values changed")
+	}
+
+	// With encryption on, a value edited into the file by hand is still
+	// plaintext and passes through until the next save seals it.
+	t.Setenv("XPOST_MASTER_KEY", hex.EncodeToString(testMasterKey(7)))
+	mixed := testSecretsConfig()
+	sealed, err := sealSecret(testMasterKey(7), "api_secret", "api-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mixed.X.APISecret = sealed
+	if err := mixed.openSecrets(); err != nil {
+		t.Fatalf("openSecrets: %v", err)
+	}
+	if !sameSecrets(&mixed.X, &testSecretsConfig().X) {
+		t.Errorf("mixed config opened to %v", secretValues(&mixed.X))
+	}
+}
+
+func TestParseMasterKey(t *testing.T) {
+	key := testMasterKey(9)
+	for _, raw := range []string{
+		hex.EncodeToString(key),
+		encodeMasterKey(key),
+		strings.TrimRight(encodeMasterKey(key), "=") + "\n",
+	} {
+		got, err := parseMasterKey(raw)
+		if err != nil || string(got) != string(key) {
+			t.Errorf("parseMasterKey(%q) = %x, %v", raw, got, err)
+		}
+	}
+	for _, raw := range []string{"", "short", hex.EncodeToString(key[:16]), encodeMasterKey(append(key, 1))} {
+		if _, err := parseMasterKey(raw); err == nil {
+			t.Errorf("parseMasterKey(%q) accepted", raw)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	storeLockTimeout = 10 * time.Second
+	storeLockStale   = 30 * time.Second
+)
+
+// jsonStore is a JSON document on disk next to the config file. The server
+// and CLI commands may touch the same file, so every read-modify-write holds
+// both an in-process mutex and a lock file.
+type jsonStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func newJSONStore(path string) *jsonStore {
+	return &jsonStore{path: filepath.Clean(path)}
+}
+
+// load decodes the file into v. A missing or empty file leaves v untouched.
+func (s *jsonStore) load(v any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return readJSONFile(s.path, v)
+}
+
+// update loads the file into v, runs fn and writes v back if fn succeeds.
+func (s *jsonStore) update(v any, fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := acquireFileLock(s.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := readJSONFile(s.path, v); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return writeJSONFile(s.path, v)
+}
+
+func readJSONFile(path string, v any) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if len(strings.TrimSpace(string(content))) == 0 {
+		return nil
+	}
+	return json.Unmarshal(content, v)
+}
+
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic writes data to a uniquely named temp file next to path and
+// renames it into place, so writers that don't share a lock never clobber
+// each other's half-written file.
+func writeFileAtomic(path string, data []byte) error {
+	out, err := createTempFor(path)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(data); err != nil {
+		_ = out.Close()
+		_ = os.Remove(out.Name())
+		return err
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(out.Name())
+		return err
+	}
+	return renameTemp(out.Name(), path)
+}
+
+// copyFileAtomic streams src into path through a temp file and rename.
+func copyFileAtomic(path string, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := createTempFor(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		_ = os.Remove(out.Name())
+		return err
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(out.Name())
+		return err
+	}
+	return renameTemp(out.Name(), path)
+}
+
+// createTempFor creates an owner-only temp file in the directory of path.
+func createTempFor(path string) (*os.File, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+}
+
+func renameTemp(tmpPath, path string) error {
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+func acquireFileLock(path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(storeLockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			_ = f.Close()
+			return func() { _ = os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		// A crashed process can leave its lock file behind.
+		if info, statErr := os.Stat(path); statErr == nil && time.Since(info.ModTime()) > storeLockStale {
+			_ = os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for lock %s", path)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func newRecordID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("crypto/rand.Read failed: %v", err))
+	}
+	return hex.EncodeToString(buf)
+}
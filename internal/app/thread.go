@@ -0,0 +1,253 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	xdk "github.com/missuo/xdk-go"
+)
+
+const maxThreadItems = 25
+
+type threadItemJSONRequest struct {
+	Text              string   `json:"text"`
+	MediaBase64       []string `json:"media_base64"`
+	MediaContentTypes []string `json:"media_content_types"`
+	AltText           []string `json:"alt_text"`
+}
+
+type createThreadJSONRequest struct {
+	Items          []threadItemJSONRequest `json:"items"`
+	ReplyToTweetID string                  `json:"reply_to_tweet_id"`
+	Account        string                  `json:"account"`
+}
+
+type threadItem struct {
+	Text     string
+	Media    []mediaUploadInput
+	MediaIDs []string
+	opts     tweetOptions
+}
+
+type threadResult struct {
+	TweetIDs []string   `json:"tweet_ids"`
+	Tweets   []xdk.JSON `json:"tweets"`
+}
+
+// errPostedWithoutID is returned when X accepted a post but its response
+// held no ID, so the post is most likely live.
+var errPostedWithoutID = errors.New("post was created but returned no tweet id")
+
+// threadError reports a thread that stopped part way. Posts before Index
+// went out and are listed in the accompanying threadResult.
+type threadError struct {
+	Index int
+	Err   error
+}
+
+func (e *threadError) Error() string {
+	return fmt.Sprintf("thread post %d failed: %v", e.Index+1, e.Err)
+}
+
+// postedWithoutID reports whether the failed post itself was created, in
+// which case resuming from the previous post would publish it twice.
+func (e *threadError) postedWithoutID() bool {
+	return errors.Is(e.Err, errPostedWithoutID)
+}
+
+func (e *threadError) Unwrap() error {
+	return e.Err
+}
+
+func (a *App) handleCreateThread(c *gin.Context) {
+	req, items, err := parseThreadRequest(c)
+	if err != nil {
+		c.JSON(errorResponse(err, http.StatusBadRequest, codeValidationFailed))
+		return
+	}
+	defer releaseThreadMedia(items)
+
+	for _, item := range items {
+		if len(item.Media) > 0 && !checkScope(c, scopeMediaWrite) {
+			return
+		}
+	}
+	imageCfg := a.imageConfig()
+	for i, item := range items {
+		if err := prepareMedia(item.Media, imageCfg); err != nil {
+			c.JSON(errorResponse(fmt.Errorf("items[%d]: %w", i, err), http.StatusBadRequest, codeValidationFailed))
+			return
+		}
+	}
+
+	poster, ok := a.posterForRequest(c, requestAccount(c, req.Account))
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), threadTimeout(items))
+	defer cancel()
+
+	result, err := postThread(ctx, poster, a.library, items, req.ReplyToTweetID)
+	a.persistOAuth2Token(poster)
+	c.JSON(threadResultBody(poster, result, err))
+}
+
+// threadResultBody builds the response for a posted or partly posted thread.
+func threadResultBody(poster *Poster, result threadResult, err error) (int, gin.H) {
+	if err != nil {
+		status, resp := errorResponse(err, http.StatusBadGateway, codeXError)
+		resp["ok"] = false
+		resp["account"] = poster.account
+		resp["auth_mode"] = poster.authMode
+		resp["tweet_ids"] = result.TweetIDs
+		resp["tweets"] = result.Tweets
+		resp["post_count"] = len(result.TweetIDs)
+		var te *threadError
+		postedWithoutID := false
+		if errors.As(err, &te) {
+			resp["failed_index"] = te.Index
+			if len(result.TweetIDs) > 0 || mayHavePosted(te.Err) {
+				resp["may_have_posted"] = true
+			}
+			if postedWithoutID = te.postedWithoutID(); postedWithoutID {
+				resp["posted_without_id"] = true
+			}
+		}
+		if n := len(result.TweetIDs); n > 0 && !postedWithoutID {
+			resp["resume_reply_to_tweet_id"] = result.TweetIDs[n-1]
+		}
+		return status, resp
+	}
+
+	return http.StatusOK, gin.H{
+		"ok":         true,
+		"account":    poster.account,
+		"auth_mode":  poster.authMode,
+		"tweet_ids":  result.TweetIDs,
+		"tweets":     result.Tweets,
+		"post_count": len(result.TweetIDs),
+	}
+}
+
+func parseThreadRequest(c *gin.Context) (createThreadJSONRequest, []threadItem, error) {
+	var req createThreadJSONRequest
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxJSONBodyBytes)
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return req, nil, err
+	}
+	req.ReplyToTweetID = strings.TrimSpace(req.ReplyToTweetID)
+	if len(req.Items) == 0 {
+		return req, nil, errors.New("items is required")
+	}
+	if len(req.Items) > maxThreadItems {
+		return req, nil, fmt.Errorf("too many thread items, max is %d", maxThreadItems)
+	}
+
+	items := make([]threadItem, 0, len(req.Items))
+	for i, item := range req.Items {
+		text := strings.TrimSpace(item.Text)
+		if text == "" && len(item.MediaBase64) == 0 {
+			releaseThreadMedia(items)
+			return req, nil, fmt.Errorf("items[%d]: text or media_base64 is required", i)
+		}
+		if err := checkTweetText(text); err != nil {
+			releaseThreadMedia(items)
+			return req, nil, fmt.Errorf("items[%d]: %w", i, err)
+		}
+		if len(item.MediaBase64) > maxMediaCount {
+			releaseThreadMedia(items)
+			return req, nil, fmt.Errorf("items[%d]: too many media items, max is %d", i, maxMediaCount)
+		}
+		media, err := decodeBase64Media(item.MediaBase64, item.MediaContentTypes, item.AltText)
+		if err != nil {
+			releaseThreadMedia(items)
+			return req, nil, fmt.Errorf("items[%d]: %w", i, err)
+		}
+		items = append(items, threadItem{Text: text, Media: media})
+	}
+
+	return req, items, nil
+}
+
+// postThread uploads the media of every item before creating any post, so a
+// bad file cannot leave a half-published thread behind. Each post then
+// replies to the previous one, starting from replyToTweetID when set.
+func postThread(ctx context.Context, poster *Poster, lib *mediaLibrary, items []threadItem, replyToTweetID string) (threadResult, error) {
+	result := threadResult{
+		TweetIDs: []string{},
+		Tweets:   []xdk.JSON{},
+	}
+
+	uploaded := make([][]MediaRef, len(items))
+	for i, item := range items {
+		refs, err := uploadMediaInputs(ctx, poster, lib, item.Media)
+		if err != nil {
+			return result, fmt.Errorf("thread media upload failed for post %d: %w", i+1, err)
+		}
+		uploaded[i] = append(refs, mediaRefsFromIDs(item.MediaIDs)...)
+	}
+
+	parentID := strings.TrimSpace(replyToTweetID)
+	for i, item := range items {
+		resp, err := poster.CreateTweet(ctx, item.Text, uploaded[i], parentID, item.opts)
+		if err != nil {
+			return result, &threadError{Index: i, Err: err}
+		}
+		id := tweetIDFromResponse(resp)
+		if id == "" {
+			result.Tweets = append(result.Tweets, resp)
+			return result, &threadError{Index: i, Err: errPostedWithoutID}
+		}
+		result.TweetIDs = append(result.TweetIDs, id)
+		result.Tweets = append(result.Tweets, resp)
+		parentID = id
+	}
+
+	return result, nil
+}
+
+// autoThreadItems turns a request whose text was split into thread items.
+// Media, the quote and the poll go on the first post only; reply settings
+// apply to every post, so the rest of the thread is not open to replies the
+// first post restricts.
+func autoThreadItems(req tweetRequest) []threadItem {
+	items := make([]threadItem, 0, len(req.threadParts))
+	for i, part := range req.threadParts {
+		item := threadItem{Text: part, opts: tweetOptions{ReplySettings: req.ReplySettings}}
+		if i == 0 {
+			item.Media = req.Media
+			item.MediaIDs = req.MediaIDs
+			item.opts = req.tweetOptions
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+func releaseThreadMedia(items []threadItem) {
+	for _, item := range items {
+		releaseMedia(item.Media)
+	}
+}
+
+func threadTimeout(items []threadItem) time.Duration {
+	groups := make([][]mediaUploadInput, 0, len(items))
+	for _, item := range items {
+		groups = append(groups, item.Media)
+	}
+	return uploadTimeout(groups...)
+}
+
+func tweetIDFromResponse(resp xdk.JSON) string {
+	data, ok := resp["data"].(map[string]any)
+	if !ok {
+		return ""
+	}
+	return stringify(data["id"])
+}
@@ -0,0 +1,189 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	xdk "github.com/missuo/xdk-go"
+)
+
+// stubX stands in for POST /2/tweets. respond gets the 0-based number of the
+// call and writes the answer; the parent each post replied to is recorded.
+type stubX struct {
+	mu      sync.Mutex
+	replyTo []string
+	respond func(w http.ResponseWriter, call int)
+}
+
+func (s *stubX) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/2/tweets" {
+		http.NotFound(w, r)
+		return
+	}
+	var body struct {
+		Reply struct {
+			InReplyToTweetID string `json:"in_reply_to_tweet_id"`
+		} `json:"reply"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	s.mu.Lock()
+	call := len(s.replyTo)
+	s.replyTo = append(s.replyTo, body.Reply.InReplyToTweetID)
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	s.respond(w, call)
+}
+
+func postedTweet(w http.ResponseWriter, call int) {
+	w.Write([]byte(`{"data": {"id": "` + strconv.Itoa(100+call) + `", "text": "t"}}`))
+}
+
+// stubPoster returns a Poster for the default account that talks to x.
+func stubPoster(t *testing.T, x http.Handler) *Poster {
+	t.Helper()
+	srv := httptest.NewServer(x)
+	t.Cleanup(srv.Close)
+	client := xdk.NewClient(xdk.Config{BaseURL: srv.URL, AccessToken: "x", HTTPClient: newXHTTPClient(defaultAccountName)})
+	return &Poster{client: client, authMode: "oauth2", account: defaultAccountName}
+}
+
+func postThreadRequest(t *testing.T, x http.Handler, body string) (int, map[string]any) {
+	t.Helper()
+	a := &App{
+		cfg:     &Config{Security: SecurityConfig{envToken: "t"}},
+		posters: map[string]*Poster{defaultAccountName: stubPoster(t, x)},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/v1/threads", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer t")
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	newRouter(a).ServeHTTP(w, req)
+	var resp map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("response %q: %v", w.Body, err)
+	}
+	return w.Code, resp
+}
+
+const threeItemThread = `{"reply_to_tweet_id": "42", "items": [{"text": "one"}, {"text": "two"}, {"text": "three"}]}`
+
+func TestCreateThread(t *testing.T) {
+	x := &stubX{respond: postedTweet}
+	status, resp := postThreadRequest(t, x, threeItemThread)
+	if status != http.StatusOK || resp["ok"] != true {
+		t.Fatalf("status %d: %v", status, resp)
+	}
+	if got := resp["tweet_ids"]; len(got.([]any)) != 3 || resp["post_count"] != float64(3) {
+		t.Errorf("tweet_ids = %v, post_count = %v", got, resp["post_count"])
+	}
+	if got := strings.Join(x.replyTo, ","); got != "42,100,101" {
+		t.Errorf("posts replied to %s, want 42,100,101", got)
+	}
+}
+
+func TestCreateThreadPartialFailure(t *testing.T) {
+	tests := []struct {
+		name        string
+		respond     func(w http.ResponseWriter, call int)
+		wantResume  bool
+		wantNoID    bool
+		wantPosts   int
+		wantFailure float64
+	}{
+		{
+			name: "rejected",
+			respond: func(w http.ResponseWriter, call int) {
+				if call == 1 {
+					w.WriteHeader(http.StatusForbidden)
+					w.Write([]byte(`{"title": "Forbidden", "detail": "not allowed"}`))
+					return
+				}
+				postedTweet(w, call)
+			},
+			wantResume:  true,
+			wantPosts:   1,
+			wantFailure: 1,
+		},
+		{
+			name: "posted without id",
+			respond: func(w http.ResponseWriter, call int) {
+				if call == 1 {
+					w.Write([]byte(`{"data": {}}`))
+					return
+				}
+				postedTweet(w, call)
+			},
+			wantNoID:    true,
+			wantPosts:   1,
+			wantFailure: 1,
+		},
+		{
+			name: "first post without id",
+			respond: func(w http.ResponseWriter, call int) {
+				w.Write([]byte(`{"data": {}}`))
+			},
+			wantNoID: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x := &stubX{respond: tt.respond}
+			status, resp := postThreadRequest(t, x, threeItemThread)
+			if status < 400 || resp["ok"] != false {
+				t.Fatalf("status %d: %v", status, resp)
+			}
+			if len(x.replyTo) != int(tt.wantFailure)+1 {
+				t.Errorf("%d posts attempted, want the thread to stop at the failure", len(x.replyTo))
+			}
+			if resp["failed_index"] != tt.wantFailure || resp["post_count"] != float64(tt.wantPosts) {
+				t.Errorf("failed_index %v, post_count %v", resp["failed_index"], resp["post_count"])
+			}
+			if _, ok := resp["resume_reply_to_tweet_id"]; ok != tt.wantResume {
+				t.Errorf("resume_reply_to_tweet_id = %v, want present %v", resp["resume_reply_to_tweet_id"], tt.wantResume)
+			}
+			if tt.wantResume && resp["resume_reply_to_tweet_id"] != "100" {
+				t.Errorf("resume from %v, want 100", resp["resume_reply_to_tweet_id"])
+			}
+			if got := resp["posted_without_id"] == true; got != tt.wantNoID {
+				t.Errorf("posted_without_id = %v, want %v", resp["posted_without_id"], tt.wantNoID)
+			}
+			if tt.wantNoID && resp["may_have_posted"] != true {
+				t.Error("may_have_posted not set for a post created without an id")
+			}
+		})
+	}
+}
+
+func TestAutoThreadItemsOptions(t *testing.T) {
+	req := tweetRequest{
+		MediaIDs:    []string{"7"},
+		threadParts: []string{"one", "two", "three"},
+		tweetOptions: tweetOptions{
+			QuoteTweetID:        "42",
+			ReplySettings:       "following",
+			ExcludeReplyUserIDs: []string{"9"},
+			Poll:                &tweetPoll{Options: []string{"a", "b"}, DurationMinutes: 60},
+		},
+	}
+	items := autoThreadItems(req)
+	if len(items) != 3 {
+		t.Fatalf("%d items, want 3", len(items))
+	}
+	first := items[0]
+	if first.opts.QuoteTweetID != "42" || first.opts.Poll == nil || len(first.MediaIDs) != 1 {
+		t.Errorf("first post = %+v, want the quote, poll and media", first)
+	}
+	for i, item := range items {
+		if item.opts.ReplySettings != "following" {
+			t.Errorf("post %d: reply settings %q, want following", i+1, item.opts.ReplySettings)
+		}
+		if i > 0 && (item.opts.QuoteTweetID != "" || item.opts.Poll != nil || item.MediaIDs != nil || item.opts.ExcludeReplyUserIDs != nil) {
+			t.Errorf("post %d = %+v, want only reply settings", i+1, item)
+		}
+	}
+}
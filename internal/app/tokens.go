@@ -0,0 +1,408 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	scopeTweetsWrite  = "tweets:write"
+	scopeTimelineRead = "timeline:read"
+	scopeMediaWrite   = "media:write"
+	scopeMetricsRead  = "metrics:read"
+	scopeAll          = "*"
+)
+
+var knownScopes = []string{scopeTweetsWrite, scopeTimelineRead, scopeMediaWrite, scopeMetricsRead}
+
+// legacyTokenID names the single security.api_token, which keeps full access
+// after it is migrated into api_tokens.
+const legacyTokenID = "legacy"
+
+// envTokenID names the XPOST_API_TOKEN token. It lives only in memory.
+const envTokenID = "env"
+
+// scrypt parameters for api tokens at rest.
+const (
+	tokenHashN      = 1 << 15
+	tokenHashR      = 8
+	tokenHashP      = 1
+	tokenHashKeyLen = 32
+)
+
+const apiTokenContextKey = "xpost.api_token"
+
+// APIToken is a labelled bearer credential limited to a set of scopes and,
+// optionally, to a set of accounts.
+//
+// Only a salted scrypt hash is stored. Hint is the first two bytes of the
+// token's SHA-256, which narrows the candidates to hash on each request
+// without giving an offline attacker anything useful.
+type APIToken struct {
+	ID        string   `json:"id"`
+	Label     string   `json:"label,omitempty"`
+	TokenHash string   `json:"token_hash,omitempty"`
+	Hint      string   `json:"hint,omitempty"`
+	Scopes    []string `json:"scopes"`
+	Accounts  []string `json:"accounts,omitempty"`
+	CreatedAt int64    `json:"created_at,omitempty"`
+	ExpiresAt int64    `json:"expires_at,omitempty"`
+
+	// Token is a plaintext value written by older versions. It is hashed and
+	// cleared when the config is loaded.
+	Token string `json:"token,omitempty"`
+
+	plain string
+}
+
+func (t APIToken) hasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scopeAll) || slices.Contains(t.Scopes, scope)
+}
+
+// allowsAccount reports whether the token may act for account. A token
+// without an account list may use every account.
+func (t APIToken) allowsAccount(account string) bool {
+	return len(t.Accounts) == 0 || slices.Contains(t.Accounts, account)
+}
+
+func (t APIToken) expired(now time.Time) bool {
+	return t.ExpiresAt > 0 && now.Unix() >= t.ExpiresAt
+}
+
+func (a *App) authMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		a.reloadAPITokensIfChanged()
+
+		token, configured, ok := a.lookupAPIToken(readTokenFromRequest(c.Request))
+		if !configured {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, errorBody(codeNotConfigured, "api token is not configured"))
+			return
+		}
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errorBody(codeUnauthorized, "invalid api token"))
+			return
+		}
+		if token.expired(time.Now()) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errorBody(codeUnauthorized, "api token expired"))
+			return
+		}
+		c.Set(apiTokenContextKey, token)
+		c.Next()
+	}
+}
+
+// requireScope rejects requests whose token lacks scope.
+func requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkScope(c, scope) {
+			return
+		}
+		c.Next()
+	}
+}
+
+func checkScope(c *gin.Context, scope string) bool {
+	if requestAPIToken(c).hasScope(scope) {
+		return true
+	}
+	c.AbortWithStatusJSON(http.StatusForbidden, errorBody(codeForbidden, fmt.Sprintf("api token lacks scope %s", scope)))
+	return false
+}
+
+func checkAccountAllowed(c *gin.Context, account string) bool {
+	if requestAPIToken(c).allowsAccount(account) {
+		return true
+	}
+	c.AbortWithStatusJSON(http.StatusForbidden, errorBody(codeForbidden, fmt.Sprintf("api token is not allowed to use account %s", account)))
+	return false
+}
+
+func requestAPIToken(c *gin.Context) APIToken {
+	if v, ok := c.Get(apiTokenContextKey); ok {
+		if token, ok := v.(APIToken); ok {
+			return token
+		}
+	}
+	return APIToken{}
+}
+
+func (a *App) lookupAPIToken(got string) (APIToken, bool, bool) {
+	a.mu.RLock()
+	tokens := a.cfg.Security.allTokens()
+	a.mu.RUnlock()
+	if len(tokens) == 0 {
+		return APIToken{}, false, false
+	}
+	if got == "" {
+		return APIToken{}, true, false
+	}
+
+	digest := sha256.Sum256([]byte(got))
+	if id, ok := a.cachedTokenID(digest); ok {
+		for _, token := range tokens {
+			if token.ID == id {
+				return token, true, true
+			}
+		}
+	}
+
+	for _, token := range tokens {
+		if verifyAPIToken(got, digest, token) {
+			a.cacheTokenID(digest, token.ID)
+			return token, true, true
+		}
+	}
+	return APIToken{}, true, false
+}
+
+// The verified-token cache keeps scrypt off the hot path. It is keyed by the
+// token's SHA-256 and cleared whenever the token list is reloaded.
+func (a *App) cachedTokenID(digest [sha256.Size]byte) (string, bool) {
+	a.tokenCacheMu.Lock()
+	defer a.tokenCacheMu.Unlock()
+	id, ok := a.tokenCache[digest]
+	return id, ok
+}
+
+func (a *App) cacheTokenID(digest [sha256.Size]byte, id string) {
+	a.tokenCacheMu.Lock()
+	defer a.tokenCacheMu.Unlock()
+	if a.tokenCache == nil {
+		a.tokenCache = make(map[[sha256.Size]byte]string)
+	}
+	a.tokenCache[digest] = id
+}
+
+func (a *App) clearTokenCache() {
+	a.tokenCacheMu.Lock()
+	defer a.tokenCacheMu.Unlock()
+	a.tokenCache = nil
+}
+
+func verifyAPIToken(got string, digest [sha256.Size]byte, token APIToken) bool {
+	if token.plain != "" {
+		return subtle.ConstantTimeCompare([]byte(got), []byte(token.plain)) == 1
+	}
+	if token.TokenHash == "" || token.Hint != tokenHint(digest) {
+		return false
+	}
+	return checkTokenHash(got, token.TokenHash)
+}
+
+func (s SecurityConfig) allTokens() []APIToken {
+	tokens := make([]APIToken, 0, len(s.APITokens)+1)
+	if strings.TrimSpace(s.envToken) != "" {
+		tokens = append(tokens, APIToken{
+			ID:     envTokenID,
+			Label:  "XPOST_API_TOKEN",
+			Scopes: []string{scopeAll},
+			plain:  s.envToken,
+		})
+	}
+	for _, token := range s.APITokens {
+		if strings.TrimSpace(token.TokenHash) == "" {
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// newHashedAPIToken generates a token and returns it in plaintext together
+// with its hashed record. The plaintext is never stored.
+func newHashedAPIToken(id string, label string, scopes []string) (string, APIToken, error) {
+	plain := generateToken()
+	token := APIToken{
+		ID:        id,
+		Label:     label,
+		Scopes:    scopes,
+		CreatedAt: time.Now().Unix(),
+	}
+	if err := token.setSecret(plain); err != nil {
+		return "", APIToken{}, err
+	}
+	return plain, token, nil
+}
+
+// announceGeneratedToken shows a token generated on first boot. It goes to
+// out only when that is a terminal: the log of a service ends up in journald
+// or a log collector, which would undo keeping just the hash.
+func announceGeneratedToken(out *os.File, token, configPath string) {
+	if token == "" {
+		return
+	}
+	if info, err := out.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprintf(out, "API token generated, store it now (only its hash is kept in %s): %s\n", configPath, token)
+		return
+	}
+	log.Printf("API token %q generated without a terminal to show it on; create one with `xpost token create` and remove this one with `xpost token revoke %s`", legacyTokenID, legacyTokenID)
+}
+
+func (t *APIToken) setSecret(plain string) error {
+	hash, err := hashToken(plain)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(plain))
+	t.TokenHash = hash
+	t.Hint = tokenHint(digest)
+	t.Token = ""
+	return nil
+}
+
+// migrateAPITokens hashes plaintext tokens left by older versions: the single
+// api_token becomes the "legacy" entry and plaintext api_tokens entries are
+// hashed in place. It reports whether anything changed.
+func migrateAPITokens(s *SecurityConfig) (bool, error) {
+	changed := false
+	if plain := strings.TrimSpace(s.APIToken); plain != "" {
+		token := APIToken{
+			ID:     legacyTokenID,
+			Label:  "api_token",
+			Scopes: []string{scopeAll},
+		}
+		if err := token.setSecret(plain); err != nil {
+			return false, err
+		}
+		s.APITokens = append([]APIToken{token}, s.APITokens...)
+		s.APIToken = ""
+		changed = true
+	}
+	for i := range s.APITokens {
+		if plain := strings.TrimSpace(s.APITokens[i].Token); plain != "" {
+			if err := s.APITokens[i].setSecret(plain); err != nil {
+				return false, err
+			}
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+func tokenHint(digest [sha256.Size]byte) string {
+	return hex.EncodeToString(digest[:2])
+}
+
+func hashToken(plain string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(plain), salt, tokenHashN, tokenHashR, tokenHashP, tokenHashKeyLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("scrypt$%d$%d$%d$%s$%s",
+		tokenHashN, tokenHashR, tokenHashP,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func checkTokenHash(plain string, encoded string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "scrypt" {
+		return false
+	}
+	n, errN := strconv.Atoi(parts[1])
+	r, errR := strconv.Atoi(parts[2])
+	p, errP := strconv.Atoi(parts[3])
+	if errN != nil || errR != nil || errP != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	got, err := scrypt.Key([]byte(plain), salt, n, r, p, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// reloadAPITokensIfChanged picks up tokens created or revoked by the CLI
+// while the server is running.
+func (a *App) reloadAPITokensIfChanged() {
+	if !a.persistCfg || strings.TrimSpace(a.configPath) == "" {
+		return
+	}
+	info, err := os.Stat(a.configPath)
+	if err != nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if info.ModTime().Equal(a.cfgModTime) {
+		return
+	}
+	if err := a.reloadAPITokensLocked(); err != nil {
+		log.Printf("warning: failed to reload api tokens: %v", err)
+		return
+	}
+	a.cfgModTime = info.ModTime()
+}
+
+func (a *App) reloadAPITokensLocked() error {
+	var disk Config
+	if err := readJSONFile(a.configPath, &disk); err != nil {
+		return err
+	}
+	a.cfg.Security.APITokens = disk.Security.APITokens
+	a.clearTokenCache()
+	return nil
+}
+
+func normalizeScopes(raw []string) ([]string, error) {
+	scopes := uniqueNonEmpty(raw)
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("at least one scope is required (%s, or %s for all)", strings.Join(knownScopes, ", "), scopeAll)
+	}
+	for _, scope := range scopes {
+		if scope != scopeAll && !slices.Contains(knownScopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q (known: %s)", scope, strings.Join(knownScopes, ", "))
+		}
+	}
+	return scopes, nil
+}
+
+// parseTokenExpiry accepts a duration such as "720h" or "30d", or an
+// RFC 3339 timestamp. An empty value means the token never expires.
+func parseTokenExpiry(raw string, now time.Time) (int64, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(raw, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err == nil && n > 0 {
+			return now.Add(time.Duration(n) * 24 * time.Hour).Unix(), nil
+		}
+	}
+	if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+		return now.Add(d).Unix(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil && t.After(now) {
+		return t.Unix(), nil
+	}
+	return 0, fmt.Errorf("invalid expiry %q, use a duration like 720h or 30d, or a future RFC 3339 time", raw)
+}
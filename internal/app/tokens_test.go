@@ -0,0 +1,300 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashToken(t *testing.T) {
+	hash, err := hashToken("s3cret")
+	if err != nil {
+		t.Fatalf("hashToken: %v", err)
+	}
+	if !strings.HasPrefix(hash, "scrypt$32768$8$1$") || strings.Contains(hash, "s3cret") {
+		t.Fatalf("unexpected hash format %q", hash)
+	}
+	if !checkTokenHash("s3cret", hash) {
+		t.Error("hash does not verify its own token")
+	}
+	if checkTokenHash("s3cret ", hash) || checkTokenHash("", hash) {
+		t.Error("hash verifies a different token")
+	}
+	if other, _ := hashToken("s3cret"); other == hash {
+		t.Error("two hashes of the same token share a salt")
+	}
+	for _, bad := range []string{"", "s3cret", "bcrypt$1$2$3$4$5", "scrypt$x$8$1$AAAA$AAAA", "scrypt$32768$8$1$!!$AAAA", "scrypt$32768$8$1$AAAA$"} {
+		if checkTokenHash("s3cret", bad) {
+			t.Errorf("malformed hash %q verified", bad)
+		}
+	}
+}
+
+func TestVerifyAPITokenHint(t *testing.T) {
+	plain, token, err := newHashedAPIToken("ci", "ci", []string{scopeTweetsWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte(plain))
+	if !verifyAPIToken(plain, digest, token) {
+		t.Fatal("token does not verify")
+	}
+	// A wrong hint skips the scrypt check entirely.
+	token.Hint = "zzzz"
+	if verifyAPIToken(plain, digest, token) {
+		t.Error("token verified despite a hint mismatch")
+	}
+}
+
+// testTokenApp serves the API with cfg and reloads it from configPath, if
+// set, the way xpost serve does.
+func testTokenApp(cfg *Config, configPath string) http.Handler {
+	return newRouter(&App{cfg: cfg, configPath: configPath, persistCfg: configPath != ""})
+}
+
+// authStatus reports how a protected route answers token. GET /v1/jobs/:id
+// without a job queue is 404 for any caller that gets past auth.
+func authStatus(h http.Handler, token string) int {
+	req := httptest.NewRequest(http.MethodGet, "/v1/jobs/none", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestLookupAPITokenHintCollision(t *testing.T) {
+	plainA, tokenA, err := newHashedAPIToken("a", "a", []string{scopeAll})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two bytes of hint collide for real tokens now and then; find a token
+	// that shares A's hint.
+	var plainB string
+	for i := 0; ; i++ {
+		plainB = "collide-" + strconv.Itoa(i)
+		if tokenHint(sha256.Sum256([]byte(plainB))) == tokenA.Hint {
+			break
+		}
+	}
+	tokenB := APIToken{ID: "b", Scopes: []string{scopeAll}}
+	if err := tokenB.setSecret(plainB); err != nil {
+		t.Fatal(err)
+	}
+	a := &App{cfg: &Config{Security: SecurityConfig{APITokens: []APIToken{tokenA, tokenB}}}}
+
+	for plain, want := range map[string]string{plainA: "a", plainB: "b"} {
+		for range 2 { // the second lookup comes from the cache
+			got, configured, ok := a.lookupAPIToken(plain)
+			if !configured || !ok || got.ID != want {
+				t.Errorf("lookup = %q, %v, %v; want %q", got.ID, configured, ok, want)
+			}
+		}
+	}
+	if _, _, ok := a.lookupAPIToken("wrong"); ok {
+		t.Error("unknown token was accepted")
+	}
+}
+
+func TestMigratedConfigAuthenticatesPlaintextTokens(t *testing.T) {
+	t.Setenv("XPOST_API_TOKEN", "")
+	path := filepath.Join(t.TempDir(), "config.json")
+	old := `{"security": {
+		"api_token": "legacy-secret",
+		"api_tokens": [{"id": "ci", "token": "ci-secret", "scopes": ["media:write"]}]
+	}}`
+	if err := os.WriteFile(path, []byte(old), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, _, err := loadOrInitConfig(path)
+	if err != nil {
+		t.Fatalf("loadOrInitConfig: %v", err)
+	}
+	stored, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(stored), "legacy-secret") || strings.Contains(string(stored), "ci-secret") {
+		t.Fatalf("plaintext token left in migrated config:\n%s", stored)
+	}
+	if len(cfg.Security.APITokens) != 2 || cfg.Security.APITokens[0].ID != legacyTokenID {
+		t.Fatalf("migrated tokens = %+v", cfg.Security.APITokens)
+	}
+	if cfg.Security.generatedToken != "" {
+		t.Error("a new token was generated for a config that had one")
+	}
+
+	// Reload from disk so only the hashes are left to check against.
+	cfg, _, err = loadOrInitConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := testTokenApp(cfg, path)
+	for token, want := range map[string]int{
+		"legacy-secret": http.StatusNotFound,
+		"ci-secret":     http.StatusForbidden, // authenticated, but lacks the scope
+		"other":         http.StatusUnauthorized,
+	} {
+		if got := authStatus(h, token); got != want {
+			t.Errorf("token %q: status %d, want %d", token, got, want)
+		}
+	}
+}
+
+func TestExpiredTokenRejected(t *testing.T) {
+	plain, token, err := newHashedAPIToken("old", "old", []string{scopeAll})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	h := testTokenApp(&Config{Security: SecurityConfig{APITokens: []APIToken{token}}}, "")
+	if got := authStatus(h, plain); got != http.StatusUnauthorized {
+		t.Errorf("expired token: status %d, want 401", got)
+	}
+}
+
+func TestRevokedTokenRejected(t *testing.T) {
+	t.Setenv("XPOST_API_TOKEN", "")
+	path := filepath.Join(t.TempDir(), "config.json")
+	t.Setenv("XPOST_CONFIG", path)
+
+	plain, token, err := newHashedAPIToken("bot", "bot", []string{scopeAll})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, keep, err := newHashedAPIToken("keep", "keep", []string{scopeAll})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Server: ServerConfig{Addr: defaultServerAddr}, Security: SecurityConfig{APITokens: []APIToken{token, keep}}}
+	if err := saveConfig(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+	h := testTokenApp(cfg, path)
+	if got := authStatus(h, plain); got != http.StatusNotFound {
+		t.Fatalf("before revoke: status %d, want 404", got)
+	}
+
+	if err := runTokenRevokeCommand([]string{"bot"}); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	// Make sure the server sees a new modification time.
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := authStatus(h, plain); got != http.StatusUnauthorized {
+		t.Errorf("after revoke: status %d, want 401", got)
+	}
+	if err := runTokenRevokeCommand([]string{"bot"}); err == nil {
+		t.Error("revoking an unknown token succeeded")
+	}
+}
+
+func TestTokenCommandsKeepEnvOffDisk(t *testing.T) {
+	t.Setenv("XPOST_API_TOKEN", "")
+	path := filepath.Join(t.TempDir(), "config.json")
+	t.Setenv("XPOST_CONFIG", path)
+	_, keep, err := newHashedAPIToken("keep", "keep", []string{scopeAll})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Server: ServerConfig{Addr: defaultServerAddr}, Security: SecurityConfig{APITokens: []APIToken{keep}}}
+	if err := saveConfig(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("X_API_SECRET", "env-only-secret")
+	t.Setenv("XPOST_METRICS_ADDR", "127.0.0.1:9999")
+	if err := runTokenCreateCommand([]string{"-label", "ci", "-scope", scopeTweetsWrite}); err != nil {
+		t.Fatalf("token create: %v", err)
+	}
+	if err := runTokenRevokeCommand([]string{"keep"}); err != nil {
+		t.Fatalf("token revoke: %v", err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leaked := range []string{"env-only-secret", "127.0.0.1:9999"} {
+		if strings.Contains(string(raw), leaked) {
+			t.Errorf("env value %q written to the config:\n%s", leaked, raw)
+		}
+	}
+	var stored Config
+	if err := readJSONFile(path, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Security.APITokens) != 1 || stored.Security.APITokens[0].Label != "ci" {
+		t.Errorf("stored tokens = %+v, want only the new ci token", stored.Security.APITokens)
+	}
+}
+
+func TestGeneratedTokenKeptOutOfLogs(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	// A service's stderr is a pipe or file, never a terminal.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	announceGeneratedToken(w, "plain-secret-token", "config.json")
+	w.Close()
+	written, _ := io.ReadAll(r)
+
+	if strings.Contains(string(written)+logged.String(), "plain-secret-token") {
+		t.Errorf("generated token written without a terminal:\n%s%s", written, logged.String())
+	}
+	if !strings.Contains(logged.String(), "xpost token create") {
+		t.Errorf("log %q does not say how to get a token", logged.String())
+	}
+}
+
+func TestParseTokenExpiry(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		raw     string
+		want    time.Time
+		wantErr bool
+	}{
+		{raw: "", want: time.Unix(0, 0)},
+		{raw: "30d", want: now.Add(30 * 24 * time.Hour)},
+		{raw: " 7d ", want: now.Add(7 * 24 * time.Hour)},
+		{raw: "720h", want: now.Add(720 * time.Hour)},
+		{raw: "90m", want: now.Add(90 * time.Minute)},
+		{raw: "2026-06-01T00:00:00Z", want: time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{raw: "0d", wantErr: true},
+		{raw: "-1d", wantErr: true},
+		{raw: "-5h", wantErr: true},
+		{raw: "2025-01-01T00:00:00Z", wantErr: true},
+		{raw: "soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTokenExpiry(tt.raw, now)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseTokenExpiry(%q) = %d, want error", tt.raw, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTokenExpiry(%q): %v", tt.raw, err)
+			continue
+		}
+		if got != tt.want.Unix() {
+			t.Errorf("parseTokenExpiry(%q) = %s, want %s", tt.raw, time.Unix(got, 0).UTC(), tt.want)
+		}
+	}
+}
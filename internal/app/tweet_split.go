@@ -0,0 +1,162 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// tweetTagPattern matches hashtags, mentions and cashtags, which a split must
+// keep whole just like URLs.
+var tweetTagPattern = regexp.MustCompile(`[#@$][\p{L}\p{N}_]+`)
+
+// autoThreadText checks text for a single post. When the text is too long
+// and autoThread is set it is split into thread parts instead; a nil result
+// means the text fits in one post.
+func autoThreadText(text string, autoThread, numbered bool) ([]string, error) {
+	count := countTweetText(text)
+	if count.Valid {
+		return nil, nil
+	}
+	if !autoThread || count.Remaining >= 0 {
+		return nil, errors.New(count.Error)
+	}
+	return splitTweetText(text, numbered)
+}
+
+// splitTweetText breaks text into posts of at most maxTweetLength, cutting
+// at sentence ends where that keeps posts reasonably full, otherwise between
+// words. A word longer than a post (common in CJK text) is cut between
+// characters, but never inside a URL, hashtag, mention or emoji. With
+// numbered set every post ends in " i/n".
+func splitTweetText(text string, numbered bool) ([]string, error) {
+	text = norm.NFC.String(strings.TrimSpace(text))
+	if countTweetText(text).WeightedLength > maxThreadItems*maxTweetLength {
+		return nil, fmt.Errorf("text is too long for a thread of %d posts", maxThreadItems)
+	}
+
+	// The suffix width depends on the number of posts, which in turn depends
+	// on the room left by the suffix.
+	digits := 1
+	for {
+		budget := maxTweetLength
+		if numbered {
+			budget -= len(" /") + 2*digits
+		}
+		parts := splitTweetTextWithin(text, budget)
+		if numbered && len(fmt.Sprint(len(parts))) > digits {
+			digits++
+			continue
+		}
+		if len(parts) > maxThreadItems {
+			return nil, fmt.Errorf("text needs %d posts, max is %d", len(parts), maxThreadItems)
+		}
+		if numbered {
+			for i := range parts {
+				parts[i] = fmt.Sprintf("%s %d/%d", parts[i], i+1, len(parts))
+			}
+		}
+		for i, part := range parts {
+			if err := checkTweetText(part); err != nil {
+				return nil, fmt.Errorf("post %d: %w", i, err)
+			}
+		}
+		return parts, nil
+	}
+}
+
+func splitTweetTextWithin(text string, budget int) []string {
+	var parts []string
+	rest := text
+	for rest != "" {
+		if countTweetText(rest).WeightedLength <= budget {
+			parts = append(parts, rest)
+			break
+		}
+		cut := tweetCutPoint(rest, budget)
+		parts = append(parts, strings.TrimRightFunc(rest[:cut], unicode.IsSpace))
+		rest = strings.TrimLeftFunc(rest[cut:], unicode.IsSpace)
+	}
+	return parts
+}
+
+// tweetCutPoint returns the byte offset at which to end the next post of
+// text, which is known to be longer than budget.
+func tweetCutPoint(text string, budget int) int {
+	sentence, word := 0, 0
+	for i, r := range text {
+		var isSentence, isWord bool
+		end := i
+		switch {
+		case r == '\n':
+			isSentence = true
+		case unicode.IsSpace(r):
+			prev, _ := utf8.DecodeLastRuneInString(text[:i])
+			isSentence = strings.ContainsRune(".!?", prev)
+			isWord = true
+		case strings.ContainsRune("。！？", r):
+			isSentence, end = true, i+utf8.RuneLen(r)
+		case strings.ContainsRune("、，；", r):
+			isWord, end = true, i+utf8.RuneLen(r)
+		default:
+			continue
+		}
+		if end == 0 {
+			continue
+		}
+		if countTweetText(text[:end]).WeightedLength > budget {
+			break
+		}
+		if isSentence {
+			sentence = end
+		}
+		if isSentence || isWord {
+			word = end
+		}
+	}
+
+	// Only end on a sentence if that doesn't leave the post mostly empty.
+	if sentence > 0 && countTweetText(text[:sentence]).WeightedLength >= budget/2 {
+		return sentence
+	}
+	if word > 0 {
+		return word
+	}
+	return tweetHardCut(text, budget)
+}
+
+// tweetHardCut cuts inside a word, between whole emoji, moving the cut in
+// front of any URL or tag it would split.
+func tweetHardCut(text string, budget int) int {
+	runes := []rune(text)
+	cut, end := 0, 0
+	for end < len(runes) {
+		n := max(emojiLength(runes[end:]), 1)
+		if countTweetText(string(runes[:end+n])).WeightedLength > budget {
+			break
+		}
+		end += n
+		cut = len(string(runes[:end]))
+	}
+
+	var spans [][2]int
+	spans = append(spans, findTweetURLs(text)...)
+	for _, loc := range tweetTagPattern.FindAllStringIndex(text, -1) {
+		spans = append(spans, [2]int{loc[0], loc[1]})
+	}
+	for _, span := range spans {
+		if span[0] > 0 && span[0] < cut && cut < span[1] {
+			cut = span[0]
+		}
+	}
+	if cut == 0 {
+		// A single unit wider than budget; nothing better is possible.
+		cut = len(string(runes[:max(emojiLength(runes), 1)]))
+	}
+	return cut
+}
@@ -0,0 +1,213 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// checkThreadParts fails t unless every part is a valid post.
+func checkThreadParts(t *testing.T, parts []string) {
+	t.Helper()
+	for i, part := range parts {
+		if count := countTweetText(part); !count.Valid {
+			t.Errorf("part %d is not a valid post: %s", i, count.Error)
+		}
+	}
+}
+
+// words returns n space-separated words of five letters.
+func words(n int) string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = fmt.Sprintf("w%04d", i%10000)
+	}
+	return strings.Join(list, " ")
+}
+
+func TestAutoThreadText(t *testing.T) {
+	if parts, err := autoThreadText("short", true, true); parts != nil || err != nil {
+		t.Errorf("short text: %q, %v; want it left alone", parts, err)
+	}
+	if _, err := autoThreadText(words(100), false, false); err == nil {
+		t.Error("long text without auto_thread was accepted")
+	}
+	if _, err := autoThreadText("a\ufeff"+words(100), true, false); err == nil {
+		t.Error("invalid text was split instead of rejected")
+	}
+	parts, err := autoThreadText(words(100), true, false)
+	if err != nil || len(parts) != 3 {
+		t.Errorf("long text: %d parts, %v; want 3", len(parts), err)
+	}
+}
+
+func TestSplitTweetTextWords(t *testing.T) {
+	text := words(150) // 899 characters
+	parts, err := splitTweetText(text, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkThreadParts(t, parts)
+	if got := strings.Join(parts, " "); got != text {
+		t.Errorf("parts do not join back to the text:\n%s", got)
+	}
+	for i, part := range parts[:len(parts)-1] {
+		if n := countTweetText(part).WeightedLength; n < maxTweetLength-5 {
+			t.Errorf("part %d is %d characters, want it filled up to a word", i, n)
+		}
+	}
+}
+
+func TestSplitTweetTextSentences(t *testing.T) {
+	first := strings.Repeat("a", 200) + "."
+	second := strings.Repeat("b ", 60) + "end."
+	parts, err := splitTweetText(first+" "+second, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 2 || parts[0] != first {
+		t.Errorf("parts = %q; want a cut after the first sentence", parts)
+	}
+
+	// A sentence end that would leave the post under half full is ignored.
+	short := "Hi. " + words(60)
+	parts, err = splitTweetText(short, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parts[0] == "Hi." {
+		t.Error("split after a two-character sentence")
+	}
+	checkThreadParts(t, parts)
+}
+
+func TestSplitTweetTextNumbered(t *testing.T) {
+	tests := []struct {
+		text  string
+		posts int
+	}{
+		{text: words(60), posts: 2},
+		{text: words(150), posts: 4},
+		{text: words(500), posts: 12}, // " 10/12" leaves room for 45 words, not 46
+		{text: strings.Repeat("中", 600), posts: 5},
+	}
+	for _, tt := range tests {
+		parts, err := splitTweetText(tt.text, true)
+		if err != nil {
+			t.Errorf("%d posts: %v", tt.posts, err)
+			continue
+		}
+		if len(parts) != tt.posts {
+			t.Errorf("got %d posts, want %d", len(parts), tt.posts)
+		}
+		checkThreadParts(t, parts)
+		for i, part := range parts {
+			suffix := fmt.Sprintf(" %d/%d", i+1, len(parts))
+			if !strings.HasSuffix(part, suffix) {
+				t.Errorf("part %d = %q, want suffix %q", i, part, suffix)
+			}
+		}
+	}
+
+	// Exactly full posts must make room for the suffix.
+	parts, err := splitTweetText(strings.Repeat("a", 2*maxTweetLength), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 3 {
+		t.Errorf("got %d posts, want 3", len(parts))
+	}
+	checkThreadParts(t, parts)
+}
+
+func TestSplitTweetTextThreadCap(t *testing.T) {
+	if _, err := splitTweetText(strings.Repeat("a", maxThreadItems*maxTweetLength+1), false); err == nil ||
+		!strings.Contains(err.Error(), "too long for a thread") {
+		t.Errorf("over %d posts of text: err = %v", maxThreadItems, err)
+	}
+
+	// Fits in 25 posts without numbering, but not once " i/25" is added.
+	text := strings.Repeat("a", maxThreadItems*maxTweetLength)
+	parts, err := splitTweetText(text, false)
+	if err != nil || len(parts) != maxThreadItems {
+		t.Errorf("unnumbered: %d posts, %v; want %d", len(parts), err, maxThreadItems)
+	}
+	if _, err := splitTweetText(text, true); err == nil || !strings.Contains(err.Error(), "max is 25") {
+		t.Errorf("numbered: err = %v, want a post count error", err)
+	}
+}
+
+func TestSplitTweetTextHardCuts(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{
+			name: "long word",
+			text: strings.Repeat("a", 600),
+			want: []string{strings.Repeat("a", 280), strings.Repeat("a", 280), strings.Repeat("a", 40)},
+		},
+		{
+			name: "cjk without punctuation",
+			text: strings.Repeat("中", 300),
+			want: []string{strings.Repeat("中", 140), strings.Repeat("中", 140), strings.Repeat("中", 20)},
+		},
+		{
+			name: "cjk at a comma",
+			text: strings.Repeat("中", 100) + "，" + strings.Repeat("中", 100),
+			want: []string{strings.Repeat("中", 100) + "，", strings.Repeat("中", 100)},
+		},
+		{
+			name: "url kept whole",
+			text: strings.Repeat("中", 130) + "https://example.com/a",
+			want: []string{strings.Repeat("中", 130), "https://example.com/a"},
+		},
+		{
+			name: "hashtag kept whole",
+			text: strings.Repeat("中", 137) + "#golang",
+			want: []string{strings.Repeat("中", 137), "#golang"},
+		},
+		{
+			name: "emoji kept whole",
+			text: strings.Repeat("a", 279) + zwjFamily,
+			want: []string{strings.Repeat("a", 279), zwjFamily},
+		},
+		{
+			name: "flag kept whole",
+			text: strings.Repeat("中", 139) + "a\U0001F1EF\U0001F1F5",
+			want: []string{strings.Repeat("中", 139) + "a", "\U0001F1EF\U0001F1F5"},
+		},
+	}
+	for _, tt := range tests {
+		parts, err := splitTweetText(tt.text, false)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if strings.Join(parts, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: parts = %q, want %q", tt.name, parts, tt.want)
+		}
+		checkThreadParts(t, parts)
+	}
+}
+
+func TestSplitTweetTextLongURL(t *testing.T) {
+	// A URL counts as 23 no matter its length, so a post can hold a URL far
+	// longer than 280 bytes.
+	url := "https://example.com/" + strings.Repeat("x", 400)
+	parts, err := splitTweetText(words(50)+" "+url+" "+words(10), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkThreadParts(t, parts)
+	found := false
+	for _, part := range parts {
+		if strings.Contains(part, url) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("the URL was split: %q", parts)
+	}
+}
@@ -0,0 +1,238 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Text is counted the way X does (twitter-text v3): every code point weighs
+// 2 unless it falls in a light range, emoji sequences weigh 2 in total, and
+// each URL counts as a t.co link no matter how long it is.
+const (
+	maxTweetLength     = 280
+	tweetWeightScale   = 100
+	defaultTweetWeight = 200
+	tcoURLLength       = 23
+)
+
+type tweetWeightRange struct {
+	start, end rune
+	weight     int
+}
+
+var tweetWeightRanges = []tweetWeightRange{
+	{0x0000, 0x10FF, 100}, // Latin, Greek, Cyrillic, Hebrew, Arabic, Indic, ...
+	{0x2000, 0x200D, 100}, // spaces, zero-width joiner
+	{0x2010, 0x201F, 100}, // dashes, quotes
+	{0x2032, 0x2037, 100}, // primes
+}
+
+// invalidTweetRunes are rejected by X outright.
+var invalidTweetRunes = map[rune]bool{0xFFFE: true, 0xFEFF: true, 0xFFFF: true}
+
+// tweetURLPattern finds link candidates. Matches without a scheme only count
+// when their TLD is a known one, see findTweetURLs.
+var tweetURLPattern = regexp.MustCompile(`(?i)\b(?:https?://)?(?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)+([a-z]{2,63})(?::[0-9]{1,5})?(?:[/?#][^\s]*)?`)
+
+var genericTLDs = map[string]bool{
+	"com": true, "net": true, "org": true, "edu": true, "gov": true, "mil": true, "int": true,
+	"info": true, "biz": true, "name": true, "pro": true, "app": true, "dev": true, "xyz": true,
+	"online": true, "site": true, "tech": true, "store": true, "blog": true, "news": true,
+	"shop": true, "cloud": true, "page": true, "link": true, "live": true, "club": true,
+	"design": true, "art": true, "social": true, "media": true, "games": true,
+}
+
+var countryTLDs = toSet(strings.Fields(`
+	ac ad ae af ag ai al am ao aq ar as at au aw ax az ba bb bd be bf bg bh bi bj bm bn bo br bs bt bw by bz
+	ca cc cd cf cg ch ci ck cl cm cn co cr cu cv cw cx cy cz de dj dk dm do dz ec ee eg er es et eu fi fj fk
+	fm fo fr ga gd ge gf gg gh gi gl gm gn gp gq gr gs gt gu gw gy hk hm hn hr ht hu id ie il im in io iq ir
+	is it je jm jo jp ke kg kh ki km kn kp kr kw ky kz la lb lc li lk lr ls lt lu lv ly ma mc md me mg mh mk
+	ml mm mn mo mp mq mr ms mt mu mv mw mx my mz na nc ne nf ng ni nl no np nr nu nz om pa pe pf pg ph pk pl
+	pm pn pr ps pt pw py qa re ro rs ru rw sa sb sc sd se sg sh si sk sl sm sn so sr ss st su sv sx sy sz tc
+	td tf tg th tj tk tl tm tn to tr tt tv tw tz ua ug uk us uy uz va vc ve vg vi vn vu wf ws ye yt za zm zw`))
+
+func toSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
+
+// tweetTextCount is the result of counting a post's text.
+type tweetTextCount struct {
+	WeightedLength int    `json:"weighted_length"`
+	MaxLength      int    `json:"max_length"`
+	Remaining      int    `json:"remaining"`
+	URLs           int    `json:"urls"`
+	Valid          bool   `json:"valid"`
+	Error          string `json:"error,omitempty"`
+}
+
+// countTweetText weighs text after NFC normalization, like X does.
+func countTweetText(text string) tweetTextCount {
+	count := tweetTextCount{MaxLength: maxTweetLength}
+	if !utf8.ValidString(text) {
+		count.Error = "text is not valid UTF-8"
+		return count
+	}
+	text = norm.NFC.String(text)
+
+	weight := 0
+	pos := 0
+	for _, loc := range findTweetURLs(text) {
+		weight += weighTweetRunes(text[pos:loc[0]])
+		weight += tcoURLLength * tweetWeightScale
+		count.URLs++
+		pos = loc[1]
+	}
+	weight += weighTweetRunes(text[pos:])
+
+	count.WeightedLength = weight / tweetWeightScale
+	count.Remaining = maxTweetLength - count.WeightedLength
+	for _, r := range text {
+		if invalidTweetRunes[r] {
+			count.Error = fmt.Sprintf("text contains the invalid character U+%04X", r)
+			return count
+		}
+	}
+	if count.Remaining < 0 {
+		count.Error = fmt.Sprintf("text is %d characters long, max is %d", count.WeightedLength, maxTweetLength)
+		return count
+	}
+	count.Valid = true
+	return count
+}
+
+// checkTweetText returns an error for text X would refuse.
+func checkTweetText(text string) error {
+	if count := countTweetText(text); !count.Valid {
+		return errors.New(count.Error)
+	}
+	return nil
+}
+
+func findTweetURLs(text string) [][2]int {
+	var urls [][2]int
+	for _, m := range tweetURLPattern.FindAllStringSubmatchIndex(text, -1) {
+		start, end := m[0], m[1]
+		// Skip the domain part of e-mail addresses, mentions and paths.
+		if start > 0 && strings.ContainsRune("@./-_", rune(text[start-1])) {
+			continue
+		}
+		hasScheme := strings.HasPrefix(strings.ToLower(text[start:end]), "http")
+		tld := strings.ToLower(text[m[2]:m[3]])
+		if !hasScheme && !genericTLDs[tld] && !countryTLDs[tld] {
+			continue
+		}
+		// Trailing punctuation ends the sentence, not the link.
+		for end > m[3] && strings.ContainsRune(".,:;!?'\")]", rune(text[end-1])) {
+			end--
+		}
+		urls = append(urls, [2]int{start, end})
+	}
+	return urls
+}
+
+// weighTweetRunes sums the scaled weight of text that holds no URLs.
+func weighTweetRunes(text string) int {
+	runes := []rune(text)
+	weight := 0
+	for i := 0; i < len(runes); {
+		if n := emojiLength(runes[i:]); n > 0 {
+			weight += defaultTweetWeight
+			i += n
+			continue
+		}
+		weight += runeWeight(runes[i])
+		i++
+	}
+	return weight
+}
+
+func runeWeight(r rune) int {
+	for _, wr := range tweetWeightRanges {
+		if r >= wr.start && r <= wr.end {
+			return wr.weight
+		}
+	}
+	return defaultTweetWeight
+}
+
+// emojiLength returns how many runes at the start of runes form one emoji,
+// including modifiers, variation selectors, keycaps, flags and ZWJ
+// sequences, or 0 if runes does not start with an emoji.
+func emojiLength(runes []rune) int {
+	if len(runes) == 0 {
+		return 0
+	}
+	first := runes[0]
+	n := 0
+	switch {
+	case isRegionalIndicator(first):
+		if len(runes) > 1 && isRegionalIndicator(runes[1]) {
+			return 2
+		}
+		return 1
+	case isPictographic(first):
+		n = 1
+	case len(runes) > 1 && (runes[1] == 0xFE0F || runes[1] == 0x20E3):
+		// Text characters such as digits and © only become emoji when a
+		// variation selector or keycap follows.
+		if !strings.ContainsRune("0123456789#*©®‼⁉™ℹ", first) && !(first >= 0x2194 && first <= 0x21AA) {
+			return 0
+		}
+		n = 1
+	default:
+		return 0
+	}
+	for n < len(runes) {
+		r := runes[n]
+		switch {
+		case r == 0xFE0F || r == 0xFE0E || r == 0x20E3 || (r >= 0x1F3FB && r <= 0x1F3FF) || (r >= 0xE0020 && r <= 0xE007F):
+			n++
+		case r == 0x200D && n+1 < len(runes) && isPictographic(runes[n+1]):
+			n += 2
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+func isPictographic(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF:
+		return true
+	case r >= 0x2300 && r <= 0x23FF, r >= 0x2600 && r <= 0x27BF, r >= 0x2B00 && r <= 0x2BFF:
+		return true
+	case r == 0x3030 || r == 0x303D || r == 0x3297 || r == 0x3299:
+		return true
+	}
+	return false
+}
+
+type validateTweetRequest struct {
+	Text string `json:"text"`
+}
+
+func (a *App) handleValidateTweet(c *gin.Context) {
+	var req validateTweetRequest
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxJSONBodyBytes)
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(errorResponse(err, http.StatusBadRequest, codeValidationFailed))
+		return
+	}
+	c.JSON(http.StatusOK, countTweetText(strings.TrimSpace(req.Text)))
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+// zwjFamily is man, woman, girl and boy joined by zero-width joiners.
+const zwjFamily = "\U0001F468\u200d\U0001F469\u200d\U0001F467\u200d\U0001F466"
+
+// Cases follow the weighted-length conformance tests of twitter-text v3
+// (validate.yml), plus a few for the URL and emoji edges xpost handles.
+func TestCountTweetText(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		length int
+		urls   int
+		valid  bool
+	}{
+		{name: "empty", text: "", length: 0, valid: true},
+		{name: "ascii", text: "Hello world", length: 11, valid: true},
+		{name: "280 latin", text: strings.Repeat("a", 280), length: 280, valid: true},
+		{name: "281 latin", text: strings.Repeat("a", 281), length: 281},
+		{name: "accented", text: strings.Repeat("é", 280), length: 280, valid: true},
+		{name: "cyrillic", text: "Привет", length: 6, valid: true},
+		{name: "curly quotes", text: "‘quoted’ — “yes”", length: 16, valid: true},
+
+		{name: "japanese", text: "こんにちは", length: 10, valid: true},
+		{name: "140 cjk", text: strings.Repeat("中", 140), length: 280, valid: true},
+		{name: "141 cjk", text: strings.Repeat("中", 141), length: 282},
+		{name: "hangul", text: "안녕하세요", length: 10, valid: true},
+		{name: "mixed cjk", text: "Hi 世界", length: 7, valid: true},
+		{name: "fullwidth punctuation", text: "你好。", length: 6, valid: true},
+
+		{name: "url", text: "https://example.com", length: 23, urls: 1, valid: true},
+		{name: "long url", text: "https://example.com/" + strings.Repeat("x", 300), length: 23, urls: 1, valid: true},
+		{name: "short url", text: "http://t.co", length: 23, urls: 1, valid: true},
+		{name: "text and url", text: "read https://example.com/post?id=1 now", length: 32, urls: 1, valid: true},
+		{name: "bare domain", text: "example.com", length: 23, urls: 1, valid: true},
+		{name: "bare cctld", text: "go to example.co.uk", length: 29, urls: 1, valid: true},
+		{name: "unknown tld", text: "file.txt", length: 8, valid: true},
+		{name: "email", text: "user@example.com", length: 16, valid: true},
+		{name: "trailing period", text: "see https://x.com/a.", length: 28, urls: 1, valid: true},
+		{name: "url in parens", text: "(https://x.com/a)", length: 25, urls: 1, valid: true},
+		{name: "two urls", text: "https://a.com https://b.com", length: 47, urls: 2, valid: true},
+		{name: "url after cjk", text: "見てhttps://example.com", length: 27, urls: 1, valid: true},
+		{name: "many urls", text: strings.Repeat("https://example.com/ ", 12), length: 12 * 24, urls: 12},
+
+		{name: "emoji", text: "😀", length: 2, valid: true},
+		{name: "skin tone", text: "👍🏽", length: 2, valid: true},
+		{name: "zwj family", text: zwjFamily, length: 2, valid: true},
+		{name: "zwj profession", text: "\U0001F469\U0001F3FE\u200d\U0001F680", length: 2, valid: true},
+		{name: "flag", text: "🇯🇵", length: 2, valid: true},
+		{name: "subdivision flag", text: "\U0001F3F4\U000E0067\U000E0062\U000E0073\U000E0063\U000E0074\U000E007F", length: 2, valid: true},
+		{name: "keycap", text: "1\ufe0f\u20e3", length: 2, valid: true},
+		{name: "variation selector", text: "\u2764\ufe0f", length: 2, valid: true},
+		{name: "plain digit", text: "1", length: 1, valid: true},
+		{name: "copyright text", text: "©", length: 1, valid: true},
+		{name: "mixed emoji", text: "H\U0001F431\u263a" + zwjFamily, length: 7, valid: true},
+		{name: "140 emoji", text: strings.Repeat(zwjFamily, 140), length: 280, valid: true},
+		{name: "141 emoji", text: strings.Repeat("😀", 141), length: 282},
+
+		// NFC turns e + combining acute into one code point.
+		{name: "decomposed", text: strings.Repeat("e\u0301", 280), length: 280, valid: true},
+		{name: "decomposed hangul", text: "\u1100\u1161\u11a8", length: 2, valid: true},
+		{name: "bom", text: "a\ufeffb", length: 4},
+		{name: "noncharacter", text: "a\uffff", length: 3},
+		{name: "invalid utf-8", text: "a\xffb"},
+	}
+	for _, tt := range tests {
+		got := countTweetText(tt.text)
+		if got.WeightedLength != tt.length || got.URLs != tt.urls || got.Valid != tt.valid {
+			t.Errorf("%s: length %d, urls %d, valid %v; want %d, %d, %v (%s)",
+				tt.name, got.WeightedLength, got.URLs, got.Valid, tt.length, tt.urls, tt.valid, got.Error)
+		}
+		if got.Valid != (got.Error == "") {
+			t.Errorf("%s: valid %v with error %q", tt.name, got.Valid, got.Error)
+		}
+		if got.Valid && got.Remaining != maxTweetLength-tt.length {
+			t.Errorf("%s: remaining %d, want %d", tt.name, got.Remaining, maxTweetLength-tt.length)
+		}
+	}
+}
+
+func TestCheckTweetText(t *testing.T) {
+	if err := checkTweetText(strings.Repeat("中", 140)); err != nil {
+		t.Errorf("140 CJK characters: %v", err)
+	}
+	err := checkTweetText(strings.Repeat("中", 141))
+	if err == nil || !strings.Contains(err.Error(), "282 characters long") {
+		t.Errorf("141 CJK characters: err = %v", err)
+	}
+	if err := checkTweetText("a\ufeff"); err == nil || !strings.Contains(err.Error(), "U+FEFF") {
+		t.Errorf("BOM: err = %v", err)
+	}
+}